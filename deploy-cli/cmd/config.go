@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/kev-cao/log-console/utils/pathutils"
+	"gopkg.in/yaml.v3"
+)
+
+// userConfig is the user's deploy-cli configuration, read from a YAML file.
+type userConfig struct {
+	Hooks []hook `yaml:"hooks"`
+}
+
+// globalConfigFile is the configuration file set with --config.
+var globalConfigFile string
+
+// globalConfig is the loaded configuration. It's empty if there is no
+// configuration file.
+var globalConfig userConfig
+
+// defaultConfigFile returns the configuration file used without --config,
+// ~/.config/deploy-cli/config.yaml on Linux.
+func defaultConfigFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "deploy-cli", "config.yaml"), nil
+}
+
+// loadConfig loads the configuration file. The default configuration file is
+// optional, but one set with --config must exist.
+func loadConfig() {
+	path := globalConfigFile
+	var err error
+	if path == "" {
+		if path, err = defaultConfigFile(); err != nil {
+			slog.Debug("could not find the configuration directory", "err", err)
+			return
+		}
+	} else if path, err = pathutils.AbsolutePath(path); err != nil {
+		checkErr(err)
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && globalConfigFile == "" {
+		return
+	} else if err != nil {
+		checkErr(fmt.Errorf("error reading configuration file: %w", err))
+	}
+	config, err := parseConfig(b)
+	if err != nil {
+		checkErr(fmt.Errorf("error in configuration file %s: %w", path, err))
+	}
+	globalConfig = config
+}
+
+// parseConfig parses and validates a configuration.
+func parseConfig(b []byte) (userConfig, error) {
+	var config userConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	// An empty file is an empty configuration.
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return userConfig{}, err
+	}
+	for i, hook := range config.Hooks {
+		if err := hook.validate(); err != nil {
+			return userConfig{}, fmt.Errorf("hook %d: %w", i+1, err)
+		}
+	}
+	return config, nil
+}
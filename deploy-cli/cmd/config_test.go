@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := parseConfig([]byte(`
+hooks:
+  - step: port_forward
+    when: after
+    run: echo "$DEPLOY_CLI_OUTPUT_VAULT_URL"
+  - step: teardown_k3s
+    when: before
+    on: master
+    script: ~/hooks/backup.sh
+`))
+	require.NoError(t, err)
+	require.Len(t, config.Hooks, 2)
+	require.Equal(t, "after port_forward on local: echo \"$DEPLOY_CLI_OUTPUT_VAULT_URL\"", config.Hooks[0].String())
+	require.Equal(t, hookMaster, config.Hooks[1].On)
+
+	config, err = parseConfig(nil)
+	require.NoError(t, err)
+	require.Empty(t, config.Hooks)
+
+	for _, tc := range []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"unknown step", "hooks: [{step: initVault, when: after, run: 'true'}]", `unknown step "initVault"`},
+		{"unknown phase", "hooks: [{step: init, when: during, run: 'true'}]", "when must be"},
+		{"unknown target", "hooks: [{step: init, when: after, on: vault, run: 'true'}]", "on must be one of"},
+		{"no command", "hooks: [{step: init, when: after}]", "exactly one of run and script"},
+		{"unknown field", "hook: []", "field hook not found"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseConfig([]byte(tc.config))
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
@@ -4,75 +4,237 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/fatih/structs"
 	"github.com/kev-cao/log-console/deploy-cli/dispatch"
-	"github.com/kev-cao/log-console/deploy-cli/dispatch/multipass"
+	"github.com/kev-cao/log-console/deploy-cli/pipeline"
+	"github.com/kev-cao/log-console/deploy-cli/transcript"
+	"github.com/kev-cao/log-console/utils/stringutils"
 	"github.com/kev-cao/log-console/utils/waitutils"
 	"github.com/spf13/cobra"
-	"golang.org/x/crypto/ssh"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/term"
 )
 
 var deployCmd = &cobra.Command{
 	Use:   "deploy",
 	Short: "Deploys a cluster and initializes it",
 	Long: `The deploy command is used to deploy a cluster and initialize it for use. It downloads the project 
-onto the cluster and optionally sets up K3S on the cluster.`,
-	PersistentPreRun: runDeploy,
+onto the cluster and optionally sets up K3S on the cluster.
+
+The deployment runs as a pipeline of named steps. Use --only, --skip and --from to select the steps
+to run, e.g. to pick a failed deployment up again from the step that failed.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := cmd.ValidateRequiredFlags(); err != nil {
+			checkErr(err)
+		}
+		if err := globalDeployFlags.validate(); err != nil {
+			checkErr(err)
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		// Do nothing as deploy is handled in PersistentPreRun
+		startTranscript(cmd, args)
+		dispatcher, err := getDeployDispatcher()
+		if err != nil {
+			checkErr(err)
+		}
+		if err := runPipeline(dispatcher, deploySteps(dispatcher)...); err != nil {
+			checkErr(err)
+		}
 	},
 }
 
-func runDeploy(cmd *cobra.Command, _ []string) {
-	if err := cmd.ValidateRequiredFlags(); err != nil {
-		cobra.CheckErr(err)
-	}
-	if err := globalDeployFlags.validate(); err != nil {
-		cobra.CheckErr(err)
-	}
-	dispatcher, err := dispatchers.GetDispatcher(
+// getDeployDispatcher returns the dispatcher for the cluster being deployed.
+func getDeployDispatcher() (dispatch.ClusterDispatcher, error) {
+	d, err := dispatchers.GetDispatcher(
 		structs.Map(globalDeployFlags),
 		dispatchMethod(globalDeployFlags.Method),
 	)
 	if err != nil {
-		cobra.CheckErr(err)
+		return nil, err
 	}
-	if globalDeployFlags.Launch {
-		mpDispatcher := dispatcher.(*multipass.MultipassDispatcher)
+	if globalDeployFlags.ExportScripts != "" {
+		printInfo("Writing the deployment's commands to scripts in %s.", globalDeployFlags.ExportScripts)
+	}
+	return d, nil
+}
 
-		fmt.Println(header("Launching nodes..."))
-		if err := mpDispatcher.LaunchNodes(); err != nil {
-			cobra.CheckErr(err)
+// runPipeline runs the steps selected by the deploy flags, recording the steps
+// that complete in the cluster's deployment state.
+func runPipeline(d dispatch.ClusterDispatcher, steps ...pipeline.Step) error {
+	p, err := pipeline.New(steps...)
+	if err != nil {
+		return err
+	}
+	p.Start = func(step pipeline.Step) {
+		startStep(step.Title)
+	}
+	p.StartUndo = func(step pipeline.Step) {
+		startStep(fmt.Sprintf("Undoing: %s", step.Title))
+	}
+	addHooks(p, d)
+	store, err := newClusterStateStore(
+		d,
+		clusterKey(globalDeployFlags.Method, globalDeployFlags.ClusterName, globalDeployFlags.Remotes),
+	)
+	if err != nil {
+		return err
+	}
+	var state *pipeline.State
+	switch {
+	case globalDeployFlags.ExportScripts != "":
+		// Scripts are run later, so there is nothing to record or resume.
+	case globalDryRun:
+		// Dry runs don't change the cluster, so they only read the local state.
+		state, err = store.local.Load()
+	default:
+		state, err = store.Load()
+		p.Store = store
+	}
+	if err != nil {
+		return fmt.Errorf("error loading deployment state: %w", err)
+	}
+	if state != nil {
+		if token, ok := state.Output(k3sTokenOutput); ok {
+			transcript.AddSecret(token)
+		}
+		deployState = state
+	}
+	p.State = deployState
+	if globalDeployFlags.Steps.Resume {
+		if state == nil {
+			printInfo("No previous deployment of this cluster to resume, starting from the beginning.")
+		} else if last := lastCompleted(p, state); last != "" {
+			printInfo("Resuming after step %s.", last)
 		}
 	}
-	fmt.Println(header("Waiting for cluster to be ready..."))
-	if err := waitReady(dispatcher); err != nil {
-		cobra.CheckErr(err)
+	err = p.Run(globalDeployFlags.Steps)
+	var failure *pipeline.Failure
+	if !errors.As(err, &failure) {
+		return err
+	}
+	finishStep(failure)
+	if !shouldRollBack(failure) {
+		return err
 	}
-	fmt.Println("Cluster ready.")
-	if globalDeployFlags.DownloadProject {
-		fmt.Println(header("Downloading project..."))
-		if err := downloadProject(dispatcher); err != nil {
-			cobra.CheckErr(err)
+	if undoErr := p.Undo(failure.Applied); undoErr != nil {
+		return errors.Join(err, fmt.Errorf("error rolling back: %w", undoErr))
+	}
+	printInfo("Rolled back the steps applied by this deployment.")
+	return err
+}
+
+// shouldRollBack returns whether to undo the steps applied by a failed
+// deployment. Unless --rollback is set, the user is asked if they can be.
+func shouldRollBack(failure *pipeline.Failure) bool {
+	var undoable []string
+	for _, step := range failure.Applied {
+		if step.Undo != nil {
+			undoable = append(undoable, step.Name)
 		}
-		fmt.Println("Project downloaded.")
 	}
-	if globalDeployFlags.SetupK3S {
-		fmt.Println(header("Setting up K3S on the cluster..."))
-		if err := setupK3S(dispatcher); err != nil {
-			cobra.CheckErr(err)
+	// Dry runs and exported scripts didn't apply anything.
+	if len(undoable) == 0 || globalDryRun || globalDeployFlags.ExportScripts != "" {
+		return false
+	}
+	if globalDeployFlags.Rollback {
+		return true
+	}
+	if dispatch.Output.Format == dispatch.OutputJSON || !term.IsTerminal(int(os.Stdin.Fd())) {
+		slog.Info("Use --rollback to undo the steps applied by a failed deployment.")
+		return false
+	}
+	var answer string
+	fmt.Printf(
+		"Step %s failed: %v\nUndo the steps applied by this deployment (%s)? [y/N] ",
+		failure.Step, failure.Err, strings.Join(undoable, ", "),
+	)
+	fmt.Scanln(&answer)
+	return strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")
+}
+
+// lastCompleted returns the last step of a pipeline that completed.
+func lastCompleted(p *pipeline.Pipeline, state *pipeline.State) string {
+	var last string
+	for _, name := range p.Names() {
+		if _, ok := state.Completed[name]; ok {
+			last = name
 		}
-		fmt.Println("K3S setup complete.")
+	}
+	return last
+}
+
+// deploySteps are the steps that prepare the cluster for deployments.
+func deploySteps(d dispatch.ClusterDispatcher) []pipeline.Step {
+	return []pipeline.Step{
+		{
+			Name:    "launch",
+			Title:   "Launching nodes...",
+			Enabled: func() bool { return globalDeployFlags.Launch },
+			Run: func() error {
+				launcher, ok := d.(interface{ LaunchNodes() error })
+				if !ok {
+					return errors.New("Nodes can only be launched for multipass deployments.")
+				}
+				return launcher.LaunchNodes()
+			},
+			Undo: func() error {
+				launcher, ok := d.(interface{ Teardown() error })
+				if !ok {
+					return errors.New("Nodes can only be torn down for multipass deployments.")
+				}
+				return launcher.Teardown()
+			},
+		},
+		{
+			Name:  "wait_ready",
+			Title: "Waiting for cluster to be ready...",
+			Run: func() error {
+				if err := waitReady(d); err != nil {
+					return err
+				}
+				printInfo("Cluster ready.")
+				return nil
+			},
+		},
+		{
+			Name:    "download",
+			Title:   "Downloading project...",
+			Deps:    []string{"wait_ready"},
+			Enabled: func() bool { return globalDeployFlags.DownloadProject },
+			Run: func() error {
+				if err := downloadProject(d); err != nil {
+					return err
+				}
+				printInfo("Project downloaded.")
+				return nil
+			},
+		},
+		{
+			Name:    "k3s",
+			Title:   "Setting up K3S on the cluster...",
+			Deps:    []string{"wait_ready"},
+			Enabled: func() bool { return globalDeployFlags.SetupK3S },
+			Run: func() error {
+				if err := setupK3S(d); err != nil {
+					return err
+				}
+				printInfo("K3S setup complete.")
+				return nil
+			},
+			Undo: func() error { return teardownK3s(d) },
+		},
 	}
 }
 
 var globalDeployFlags = deployFlags{
-	Env: DEV,
+	Env:           DEV,
+	LaunchOptions: newLaunchFlags(),
 }
 
 func init() {
@@ -83,6 +245,7 @@ func init() {
 		"m",
 		fmt.Sprintf("Method to use for deployment. Options: %v", dispatchMethodOptions),
 	)
+	addClusterNameFlag(deployCmd.PersistentFlags(), &globalDeployFlags.ClusterName)
 	deployCmd.PersistentFlags().VarP(
 		&globalDeployFlags.Env,
 		"env",
@@ -102,19 +265,16 @@ func init() {
 		false,
 		"Whether to launch the nodes before deployment (only for multipass)",
 	)
-	deployCmd.PersistentFlags().StringSliceVarP(
+	addRemoteFlags(
+		deployCmd.PersistentFlags(),
 		&globalDeployFlags.Remotes,
-		"remotes",
-		"r",
-		nil,
-		"User-qualified hostnames for each remote node (required for SSH deployments). First address is the master node.",
-	)
-	deployCmd.PersistentFlags().StringVarP(
 		&globalDeployFlags.IdentityFile,
-		"identity_file",
-		"i",
-		"~/.ssh/id_rsa",
-		"The identity (private key) file to use for SSH deployments.",
+		&globalDeployFlags.JumpHosts,
+	)
+	addAllowUnreachableFlag(
+		deployCmd.PersistentFlags(),
+		&globalDeployFlags.AllowUnreachable,
+		"Commands sent to unreachable nodes will fail.",
 	)
 	deployCmd.PersistentFlags().BoolVar(
 		&globalDeployFlags.SetupK3S,
@@ -128,6 +288,45 @@ func init() {
 		false,
 		"Whether to download the project onto the cluster (defaults true for multipass)",
 	)
+	deployCmd.PersistentFlags().StringVar(
+		&globalDeployFlags.ExportScripts,
+		"export_scripts",
+		"",
+		"Instead of deploying, write a bash script per node to this directory with the commands "+
+			"that would be sent to it",
+	)
+	deployCmd.PersistentFlags().StringSliceVar(
+		&globalDeployFlags.Steps.Only,
+		"only",
+		nil,
+		"Only run these deployment steps, even if they're not enabled by other flags",
+	)
+	deployCmd.PersistentFlags().StringSliceVar(
+		&globalDeployFlags.Steps.Skip,
+		"skip",
+		nil,
+		"Skip these deployment steps",
+	)
+	deployCmd.PersistentFlags().BoolVar(
+		&globalDeployFlags.Steps.Resume,
+		"resume",
+		false,
+		"Skip the deployment steps up to the last one that completed in a previous deployment of the cluster",
+	)
+	deployCmd.PersistentFlags().BoolVar(
+		&globalDeployFlags.Rollback,
+		"rollback",
+		false,
+		"Undo the steps applied by the deployment, in reverse order, if a step fails. "+
+			"Without it, interactive deployments ask whether to",
+	)
+	deployCmd.PersistentFlags().StringVar(
+		&globalDeployFlags.Steps.From,
+		"from",
+		"",
+		"Skip the deployment steps before this one",
+	)
+	addLaunchFlags(deployCmd.PersistentFlags(), &globalDeployFlags.LaunchOptions)
 
 	deployCmd.MarkPersistentFlagRequired("nodes")
 	deployCmd.MarkPersistentFlagRequired("method")
@@ -135,8 +334,16 @@ func init() {
 
 func waitReady(d dispatch.ClusterDispatcher) error {
 	if err := waitutils.WaitFunc(d.Ready, 5*time.Second, 1*time.Second); err != nil {
-		return errors.New("Cluster not ready for deployment. " +
-			"Make sure the cluster is initialized first.")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		var unhealthy []string
+		for _, status := range d.Status(ctx) {
+			if !status.Healthy() {
+				unhealthy = append(unhealthy, status.String())
+			}
+		}
+		return fmt.Errorf("Cluster not ready for deployment. "+
+			"Make sure the cluster is initialized first.\n%s", strings.Join(unhealthy, "\n"))
 	}
 	return nil
 }
@@ -185,16 +392,24 @@ func setupK3S(d dispatch.ClusterDispatcher) error {
 
 	time.Sleep(3 * time.Second) // Give K3S time to start
 
+	return joinWorkers(d, d.GetWorkerNodes())
+}
+
+// joinWorkers installs the K3S agent on the worker nodes and joins them to the
+// master node's cluster.
+func joinWorkers(d dispatch.ClusterDispatcher, workers []dispatch.Node) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 	// Get connection params for worker nodes
-	url := fmt.Sprintf("https://%s:6443", masterNode.Remote.FQDN)
+	masterNode := d.GetMasterNode()
+	url := fmt.Sprintf("https://%s:6443", masterNode.Address())
 	token, err := getK3SNodeToken(d, masterNode)
 	if err != nil {
 		return err
 	}
 	var wg errgroup.Group
-	for _, node := range d.GetWorkerNodes() {
+	progress := dispatch.NewProgressRenderer(os.Stdout, workers)
+	for _, node := range workers {
 		wg.Go(func() error {
 			if err := d.SendCommandsContext(
 				ctx,
@@ -205,12 +420,13 @@ func setupK3S(d dispatch.ClusterDispatcher) error {
 						node.Kubename, url, token,
 					),
 					dispatch.WithTimeout(3*time.Minute),
-					dispatch.WithOsPipe(),
-					dispatch.WithPrefixWriter(node),
+					dispatch.WithProgress(progress, node),
 				),
 			); err != nil {
+				progress.SetStatus(node, fmt.Sprintf("Error installing K3S agent: %v", err))
 				return err
 			}
+			progress.SetStatus(node, "K3S agent installed.")
 			return nil
 		})
 	}
@@ -220,6 +436,30 @@ func setupK3S(d dispatch.ClusterDispatcher) error {
 	return nil
 }
 
+// waitK3S waits for the K3S API server to come up on the master node and for
+// every node in the cluster to report as ready.
+func waitK3S(d dispatch.ClusterDispatcher) error {
+	master := d.GetMasterNode()
+	if err := d.SendCommands(
+		master,
+		dispatch.NewCommand(
+			// The kubeconfig is bound for the shell, so that every kubectl in
+			// the loop gets it.
+			"bash -c "+stringutils.ShellQuote(
+				"until kubectl get nodes >/dev/null 2>&1; do sleep 2; done && "+
+					"kubectl wait --for=condition=Ready node --all --timeout=300s",
+			),
+			dispatch.WithEnv(kubeEnv),
+			dispatch.WithTimeout(6*time.Minute),
+			dispatch.WithOsPipe(),
+			dispatch.WithPrefixWriter(master),
+		),
+	); err != nil {
+		return fmt.Errorf("error waiting for K3S to be healthy: %w", err)
+	}
+	return nil
+}
+
 func getK3SNodeToken(d dispatch.ClusterDispatcher, node dispatch.Node) (string, error) {
 	var token strings.Builder
 	cmd := dispatch.NewCommand(
@@ -235,12 +475,15 @@ func getK3SNodeToken(d dispatch.ClusterDispatcher, node dispatch.Node) (string,
 	); err != nil {
 		return "", err
 	}
+	transcript.AddSecret(token.String())
+	deployState.SetOutput(k3sTokenOutput, strings.TrimSpace(token.String()))
 	return strings.TrimSpace(token.String()), nil
 }
 
 func maybeTeardownK3S(d dispatch.ClusterDispatcher) error {
 	var wg errgroup.Group
 	nodes := append([]dispatch.Node{d.GetMasterNode()}, d.GetWorkerNodes()...)
+	progress := dispatch.NewProgressRenderer(os.Stdout, nodes)
 	for idx, node := range nodes {
 		wg.Go(func() error {
 			var status strings.Builder
@@ -256,24 +499,29 @@ func maybeTeardownK3S(d dispatch.ClusterDispatcher) error {
 			); err != nil {
 				return err
 			}
-			if strings.TrimSpace(status.String()) == "active" {
-				fmt.Printf("Uninstalling K3S on %s...\n", node.Name)
-				var uninstallCmd string
-				if idx == 0 {
-					uninstallCmd = "/usr/local/bin/k3s-uninstall.sh"
-				} else {
-					uninstallCmd = fmt.Sprintf("/usr/local/bin/k3s-agent-uninstall.sh")
-				}
-				return d.SendCommands(
-					node,
-					dispatch.NewCommand(
-						uninstallCmd,
-						dispatch.WithTimeout(2*time.Minute),
-						dispatch.WithOsPipe(),
-						dispatch.WithPrefixWriter(node),
-					),
-				)
+			if strings.TrimSpace(status.String()) != "active" {
+				progress.SetStatus(node, "K3S not installed.")
+				return nil
 			}
+			progress.SetStatus(node, "Uninstalling K3S...")
+			var uninstallCmd string
+			if idx == 0 {
+				uninstallCmd = "/usr/local/bin/k3s-uninstall.sh"
+			} else {
+				uninstallCmd = fmt.Sprintf("/usr/local/bin/k3s-agent-uninstall.sh")
+			}
+			if err := d.SendCommands(
+				node,
+				dispatch.NewCommand(
+					uninstallCmd,
+					dispatch.WithTimeout(2*time.Minute),
+					dispatch.WithProgress(progress, node),
+				),
+			); err != nil {
+				progress.SetStatus(node, fmt.Sprintf("Error uninstalling K3S: %v", err))
+				return err
+			}
+			progress.SetStatus(node, "K3S uninstalled.")
 			return nil
 		})
 	}
@@ -298,18 +546,9 @@ func checkInstall(
 		return postFunc(), nil
 	}
 
-	var code int
-	// Not receiving an exit error means the command failed to run for an unexpected reason
-	switch t := err.(type) {
-	case *exec.ExitError:
-		code = t.ExitCode()
-	case *ssh.ExitError:
-		code = t.ExitStatus()
-	default:
-		return false, err
-	}
-	// If the exit code is not 127, the command failed not because of a missing command
-	if code != 127 {
+	// Not receiving an exit status means the command failed to run for an unexpected reason.
+	// If the exit status is not 127, the command failed not because of a missing command.
+	if code := dispatch.ExitStatus(err); code != 127 {
 		return false, err
 	}
 	return false, nil
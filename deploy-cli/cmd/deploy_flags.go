@@ -4,18 +4,26 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/kev-cao/log-console/deploy-cli/pipeline"
 	"github.com/spf13/pflag"
 )
 
 type deployFlags struct {
-	Method          dispatchMethod
-	Env             env
-	NumNodes        int
-	Remotes         []string
-	Launch          bool
-	IdentityFile    string
-	SetupK3S        bool
-	DownloadProject bool
+	Method           dispatchMethod
+	ClusterName      clusterName
+	Env              env
+	NumNodes         int
+	Remotes          []string
+	Launch           bool
+	IdentityFile     string
+	JumpHosts        []string
+	AllowUnreachable bool
+	SetupK3S         bool
+	DownloadProject  bool
+	ExportScripts    string
+	Steps            pipeline.Selection `structs:",omitnested"`
+	Rollback         bool
+	LaunchOptions    launchFlags `structs:",omitnested"`
 }
 
 func (f *deployFlags) validate() error {
@@ -31,13 +39,20 @@ func (f *deployFlags) validate() error {
 		f.DownloadProject = true
 	}
 
+	if f.ExportScripts != "" {
+		if f.Launch {
+			return errors.New("Nodes cannot be launched when exporting scripts.")
+		}
+		if globalDryRun {
+			return errors.New("Scripts cannot be exported during a dry run.")
+		}
+	}
+
 	if f.Method == SSH {
 		if len(f.Remotes) == 0 {
 			return errors.New("Remote addresses must be provided for SSH deployments.")
 		} else if len(f.Remotes) != f.NumNodes {
 			return errors.New("Number of remotes must match number of nodes.")
-		} else if f.IdentityFile == "" {
-			return errors.New("Private key file must be provided for SSH deployments.")
 		}
 	}
 	return nil
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/pipeline"
+	"github.com/kev-cao/log-console/deploy-cli/transcript"
+	"github.com/kev-cao/log-console/utils/sliceutils"
+)
+
+// deployState is the deployment state of the cluster being deployed. Steps
+// record the values they read from the cluster as its outputs.
+var deployState = pipeline.NewState()
+
+// Outputs of the deployment steps.
+const (
+	k3sTokenOutput      = "k3s_token"
+	caFingerprintOutput = "ca_fingerprint"
+	vaultURLOutput      = "vault_url"
+)
+
+// stateConfigMap is the ConfigMap the deployment state is kept in on the cluster.
+const stateConfigMap = "deploy-cli-state"
+
+// clusterStateStore stores the deployment state of a cluster both locally and
+// in a ConfigMap on the cluster, so that a deployment can be resumed from
+// another machine. The ConfigMap is only available once K3S is set up.
+type clusterStateStore struct {
+	local pipeline.FileStore
+	d     dispatch.ClusterDispatcher
+}
+
+var _ pipeline.Store = (*clusterStateStore)(nil)
+
+// newClusterStateStore creates the store of a cluster's state. The key
+// identifies the cluster, see clusterKey.
+func newClusterStateStore(d dispatch.ClusterDispatcher, key string) (*clusterStateStore, error) {
+	dir, err := transcript.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return &clusterStateStore{
+		local: pipeline.FileStore{Path: filepath.Join(dir, "clusters", key+".json")},
+		d:     d,
+	}, nil
+}
+
+var unsafePathChars = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// clusterKey identifies a cluster: SSH clusters by their master node, and
+// multipass clusters by their name.
+func clusterKey(method dispatchMethod, name clusterName, remotes []string) string {
+	key := string(MULTIPASS)
+	if method == SSH {
+		key = SSH + "-" + remotes[0]
+	} else if name != "" {
+		key += "-" + string(name)
+	}
+	return unsafePathChars.ReplaceAllString(key, "_")
+}
+
+// Load returns the most recently updated of the local state and the state on
+// the cluster. The local state is only used if the cluster can't be asked for
+// its state, e.g. before K3S is set up, since a cluster without a state was
+// deployed from scratch since the local state was saved.
+func (s *clusterStateStore) Load() (*pipeline.State, error) {
+	local, err := s.local.Load()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := s.loadConfigMap()
+	if err != nil {
+		slog.Debug("could not read deployment state from the cluster", "err", err)
+		return local, nil
+	}
+	if remote == nil {
+		if local != nil {
+			slog.Warn("The cluster has no deployment state, ignoring the local state", "path", s.local.Path)
+		}
+		return nil, nil
+	}
+	if local == nil || remote.Updated.After(local.Updated) {
+		return remote, nil
+	}
+	return local, nil
+}
+
+// Save saves the state locally and, if K3S is set up, on the cluster.
+func (s *clusterStateStore) Save(state *pipeline.State) error {
+	if err := s.local.Save(state); err != nil {
+		return err
+	}
+	if err := s.saveConfigMap(state); err != nil {
+		slog.Debug("could not save deployment state to the cluster", "err", err)
+	}
+	return nil
+}
+
+func (s *clusterStateStore) loadConfigMap() (*pipeline.State, error) {
+	var out bytes.Buffer
+	if err := s.d.SendCommands(
+		s.d.GetMasterNode(),
+		dispatch.NewCommand(
+			fmt.Sprintf(
+				`kubectl get configmap -n kube-system %s --ignore-not-found `+
+					`-o go-template='{{index .data "state.json"}}'`,
+				stateConfigMap,
+			),
+			dispatch.WithEnv(kubeEnv),
+			dispatch.WithStdout(&out),
+			dispatch.WithTimeout(10*time.Second),
+		),
+	); err != nil {
+		return nil, err
+	}
+	if out.Len() == 0 {
+		return nil, nil
+	}
+	return pipeline.UnmarshalState(out.Bytes())
+}
+
+// saveConfigMap saves the state to the cluster, without the K3S token, which
+// anyone who can read the ConfigMap could use to join nodes to the cluster.
+func (s *clusterStateStore) saveConfigMap(state *pipeline.State) error {
+	shared := state.Copy()
+	shared.DeleteOutput(k3sTokenOutput)
+	b, err := shared.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return s.d.SendCommands(
+		s.d.GetMasterNode(),
+		dispatch.NewCommand(
+			fmt.Sprintf(
+				"kubectl create configmap -n kube-system %s --from-file=state.json=/dev/stdin "+
+					"--dry-run=client -o json <<'EOF' | kubectl apply -f -\n%s\nEOF",
+				stateConfigMap, b,
+			),
+			dispatch.WithEnv(kubeEnv),
+			dispatch.WithTimeout(10*time.Second),
+		),
+	)
+}
+
+// stepOutputs are the outputs set by steps, which are forgotten with the steps.
+var stepOutputs = map[string]string{
+	"k3s":          k3sTokenOutput,
+	"certificates": caFingerprintOutput,
+	"port_forward": vaultURLOutput,
+}
+
+// forgetSteps records that a teardown undid a deployment step and the steps
+// after it, so that they run again when the cluster is next deployed, even with
+// --resume. Steps that were partially undone are forgotten too, so it's called
+// whether or not the teardown succeeded. Without a step, the whole state is
+// deleted, e.g. after the cluster's nodes are deleted.
+func forgetSteps(d dispatch.ClusterDispatcher, key, from string) error {
+	if globalDryRun {
+		return nil
+	}
+	store, err := newClusterStateStore(d, key)
+	if err != nil {
+		return err
+	}
+	if from == "" {
+		// The ConfigMap is deleted with the cluster.
+		return store.local.Delete()
+	}
+	state, err := store.Load()
+	if err != nil || state == nil {
+		return err
+	}
+	names := sliceutils.Map(
+		append(deploySteps(nil), vaultSteps(nil)...),
+		func(step pipeline.Step, _ int) string { return step.Name },
+	)
+	for _, name := range names[slices.Index(names, from):] {
+		delete(state.Completed, name)
+		if output, ok := stepOutputs[name]; ok {
+			state.DeleteOutput(output)
+		}
+	}
+	state.Updated = time.Now()
+	return store.Save(state)
+}
+
+// fingerprint returns the SHA-256 fingerprint of a PEM encoded certificate.
+func fingerprint(cert string) string {
+	der := []byte(cert)
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(der))
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/pipeline"
+	"github.com/stretchr/testify/require"
+)
+
+// stateCluster is a cluster that only runs the commands that read and write the
+// state ConfigMap.
+type stateCluster struct {
+	dispatch.ClusterDispatcher
+	k3s       bool
+	configMap string
+}
+
+func (c *stateCluster) GetMasterNode() dispatch.Node {
+	return dispatch.Node{Name: "master"}
+}
+
+func (c *stateCluster) SendCommands(_ dispatch.Node, cmds ...dispatch.Command) error {
+	for _, cmd := range cmds {
+		if !c.k3s {
+			return errors.New("kubectl: command not found")
+		}
+		switch {
+		case strings.HasPrefix(cmd.Cmd(), "kubectl get configmap"):
+			io.WriteString(cmd.Stdout(), c.configMap)
+		case strings.HasPrefix(cmd.Cmd(), "kubectl create configmap"):
+			_, data, _ := strings.Cut(cmd.Cmd(), "\n")
+			c.configMap = strings.TrimSuffix(data, "\nEOF")
+		}
+	}
+	return nil
+}
+
+func TestClusterStateStore(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	cluster := &stateCluster{k3s: true}
+	key := clusterKey(MULTIPASS, "test", nil)
+	store, err := newClusterStateStore(cluster, key)
+	require.NoError(t, err)
+
+	state := pipeline.NewState()
+	for _, step := range []string{"wait_ready", "k3s", "cert_manager", "init", "port_forward"} {
+		state.Completed[step] = time.Now()
+	}
+	state.SetOutput(k3sTokenOutput, "K10secret")
+	state.SetOutput(vaultURLOutput, "https://master:8200")
+	state.Updated = time.Now()
+	require.NoError(t, store.Save(state))
+	// The K3S token is only kept locally.
+	require.Contains(t, cluster.configMap, "port_forward")
+	require.NotContains(t, cluster.configMap, "K10secret")
+
+	require.NoError(t, forgetSteps(cluster, key, "cert_manager"))
+	loaded, err := store.Load()
+	require.NoError(t, err)
+	require.Contains(t, loaded.Completed, "k3s")
+	require.NotContains(t, loaded.Completed, "cert_manager")
+	require.NotContains(t, loaded.Completed, "port_forward")
+	_, ok := loaded.Output(vaultURLOutput)
+	require.False(t, ok)
+
+	// Without K3S, the cluster can't be asked for its state.
+	cluster.k3s = false
+	require.NoError(t, forgetSteps(cluster, key, "k3s"))
+	loaded, err = store.Load()
+	require.NoError(t, err)
+	require.Len(t, loaded.Completed, 1)
+	require.Contains(t, loaded.Completed, "wait_ready")
+	_, ok = loaded.Output(k3sTokenOutput)
+	require.False(t, ok)
+
+	// A cluster without a state was deployed again since the local state was
+	// saved.
+	cluster.k3s, cluster.configMap = true, ""
+	loaded, err = store.Load()
+	require.NoError(t, err)
+	require.Nil(t, loaded)
+
+	cluster.k3s = false
+	require.NoError(t, forgetSteps(cluster, key, ""))
+	loaded, err = store.Load()
+	require.NoError(t, err)
+	require.Nil(t, loaded)
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/stretchr/testify/require"
+)
+
+// localCluster is a cluster whose nodes run their commands on this machine,
+// with fake kubectl, sudo and curl executables that log how they're run.
+type localCluster struct {
+	dispatch.ClusterDispatcher
+	nodes []dispatch.Node
+	bin   string
+
+	mu sync.Mutex
+	// lines are the command lines run, by node name.
+	lines map[string][]string
+}
+
+func newLocalCluster(t *testing.T, names ...string) *localCluster {
+	c := &localCluster{bin: fakeExecutables(t), lines: make(map[string][]string)}
+	for i, name := range names {
+		node := dispatch.Node{Name: name, Kubename: "master"}
+		if i > 0 {
+			node.Kubename = name
+		}
+		c.nodes = append(c.nodes, node)
+	}
+	return c
+}
+
+// fakeExecutables writes fake kubectl, sudo and curl executables to a
+// directory, and returns it. Each logs how it's run to the log file in the
+// directory.
+func fakeExecutables(t *testing.T) string {
+	bin := t.TempDir()
+	for name, body := range map[string]string{
+		// Without the kubeconfig, kubectl can't reach K3S.
+		"kubectl": `[ "$KUBECONFIG" = /etc/rancher/k3s/k3s.yaml ] || exit 1`,
+		"sudo":    `echo K10token`,
+		"curl":    ``,
+	} {
+		writeFakeExecutable(t, bin, name, body)
+	}
+	return bin
+}
+
+// writeFakeExecutable writes an executable that logs how it's run and then
+// runs the shell script body.
+func writeFakeExecutable(t *testing.T, bin, name, body string) {
+	script := "#!/bin/sh\necho \"" + name + " $*\" >> " + filepath.Join(bin, "log") + "\n" + body + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(bin, name), []byte(script), 0755))
+}
+
+// ran returns the fake executables run, in order.
+func ran(t *testing.T, bin string) []string {
+	b, err := os.ReadFile(filepath.Join(bin, "log"))
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func (c *localCluster) GetNodes() []dispatch.Node       { return c.nodes }
+func (c *localCluster) GetMasterNode() dispatch.Node    { return c.nodes[0] }
+func (c *localCluster) GetWorkerNodes() []dispatch.Node { return c.nodes[1:] }
+func (c *localCluster) Ready() bool                     { return true }
+
+func (c *localCluster) SendCommands(node dispatch.Node, cmds ...dispatch.Command) error {
+	return c.SendCommandsContext(context.Background(), node, cmds...)
+}
+
+func (c *localCluster) SendCommandsContext(ctx context.Context, node dispatch.Node, cmds ...dispatch.Command) error {
+	for _, cmd := range cmds {
+		c.mu.Lock()
+		c.lines[node.Name] = append(c.lines[node.Name], cmd.Line())
+		c.mu.Unlock()
+		local := exec.CommandContext(ctx, "bash", "-c", cmd.Line())
+		local.Env = append(os.Environ(), "PATH="+c.bin+":"+os.Getenv("PATH"))
+		local.Stdout = cmd.Stdout()
+		local.Stderr = cmd.Stderr()
+		if err := local.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWaitK3S(t *testing.T) {
+	c := newLocalCluster(t, "master")
+	require.NoError(t, waitK3S(c))
+	require.Equal(t, []string{
+		"KUBECONFIG=/etc/rancher/k3s/k3s.yaml bash -c 'until kubectl get nodes >/dev/null 2>&1; do sleep 2; done && " +
+			"kubectl wait --for=condition=Ready node --all --timeout=300s'",
+	}, c.lines["master"])
+	require.Equal(t, []string{
+		"kubectl get nodes",
+		"kubectl wait --for=condition=Ready node --all --timeout=300s",
+	}, ran(t, c.bin))
+}
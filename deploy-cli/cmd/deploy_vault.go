@@ -11,8 +11,10 @@ import (
 	"strings"
 	"time"
 
-	"github.com/fatih/structs"
 	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/pipeline"
+	"github.com/kev-cao/log-console/deploy-cli/transcript"
+	"github.com/kev-cao/log-console/k8s"
 	"github.com/kev-cao/log-console/utils/pathutils"
 	"github.com/kev-cao/log-console/utils/sliceutils"
 	"github.com/spf13/cobra"
@@ -23,29 +25,61 @@ import (
 //go:embed static/admin_policy.hcl
 var adminPolicy string
 
+// Embedded manifests of the Vault deployment.
+const (
+	vaultManifest        = "vault/vault.yaml"
+	vaultOverrides       = "vault/vault-overrides.yaml"
+	certificatesManifest = "vault/certificates.yaml"
+	trustBundleManifest  = "vault/trust-bundle.yaml"
+	certWatcherManifest  = "vault/cert-watcher.yaml"
+	certWatcherScript    = "vault/cert-watcher.sh"
+)
+
+// kmsCredentials is the file in the master node's home directory that the KMS
+// credentials are copied to until the kms secret is created from them.
+const kmsCredentials = ".deploy-cli-kms-credentials.json"
+
+// manifest returns an embedded manifest by its path in the project's k8s
+// directory.
+func manifest(name string) string {
+	b, err := k8s.Manifests.ReadFile(name)
+	if err != nil {
+		// Manifests are embedded, so a missing one is a bug.
+		panic(err)
+	}
+	return string(b)
+}
+
+// withManifest streams an embedded manifest to a command line as a heredoc,
+// which the command line must open with <<'EOF'.
+func withManifest(cmd, name string) string {
+	return fmt.Sprintf("%s\n%s\nEOF", cmd, strings.TrimSuffix(manifest(name), "\n"))
+}
+
 var deployVaultCmd = &cobra.Command{
 	Use:   "vault",
 	Short: "Deploys a vault server to the cluster.",
 	Long: `It deploys a vault server to the cluster and initializes it with the provided
 credentials.`,
-	Run: func(cmd *cobra.Command, _ []string) {
+	Run: func(cmd *cobra.Command, args []string) {
 		if err := cmd.ValidateRequiredFlags(); err != nil {
-			cobra.CheckErr(err)
+			checkErr(err)
 		}
 		globalVaultFlags.deployFlags = globalDeployFlags
 		if err := globalVaultFlags.validate(); err != nil {
-			cobra.CheckErr(err)
+			checkErr(err)
 		}
-		dispatcher, err := dispatchers.GetDispatcher(
-			structs.Map(globalVaultFlags),
-			dispatchMethod(globalVaultFlags.Method),
-		)
+		startTranscript(cmd, args)
+		dispatcher, err := getDeployDispatcher()
 		if err != nil {
-			cobra.CheckErr(err)
+			checkErr(err)
 		}
 		defer dispatcher.Cleanup()
-		if err := deployVault(dispatcher); err != nil {
-			cobra.CheckErr(err)
+		if err := runPipeline(
+			dispatcher,
+			append(deploySteps(dispatcher), vaultSteps(dispatcher)...)...,
+		); err != nil {
+			checkErr(err)
 		}
 	},
 	TraverseChildren: true,
@@ -111,7 +145,25 @@ var pkgDependencies = []pkgDependency{
 	},
 }
 
+// installDependencies installs any missing packages on the master node. Nodes
+// launched with cloud-init already have them, in which case nothing is run.
 func installDependencies(d dispatch.ClusterDispatcher) error {
+	var missing []pkgDependency
+	for _, dep := range pkgDependencies {
+		installed, err := checkInstall(d, d.GetMasterNode(), dispatch.NewCommand(dep.checkCmd), nil)
+		if err != nil {
+			return err
+		}
+		if installed {
+			printInfo("%s already installed.", dep.name)
+			continue
+		}
+		missing = append(missing, dep)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
 	if err := d.SendCommands(
 		d.GetMasterNode(),
 		dispatch.NewCommand(
@@ -122,16 +174,8 @@ func installDependencies(d dispatch.ClusterDispatcher) error {
 	); err != nil {
 		return err
 	}
-	for _, dep := range pkgDependencies {
-		installed, err := checkInstall(d, d.GetMasterNode(), dispatch.NewCommand(dep.checkCmd), nil)
-		if err != nil {
-			return err
-		}
-		if installed {
-			fmt.Printf("%s already installed.\n", dep.name)
-			continue
-		}
-		fmt.Printf("Installing %s...\n", dep.name)
+	for _, dep := range missing {
+		printInfo("Installing %s...", dep.name)
 		if err := d.SendCommands(
 			d.GetMasterNode(),
 			dispatch.NewCommands(
@@ -150,57 +194,133 @@ var kubeEnv map[string]string = map[string]string{
 	"KUBECONFIG": "/etc/rancher/k3s/k3s.yaml",
 }
 
-func deployVault(d dispatch.ClusterDispatcher) error {
-	fmt.Println(header("Installing Dependencies..."))
-	if err := installDependencies(d); err != nil {
-		return err
-	}
-	fmt.Println(header("Installing Cert-Manager..."))
-	if err := initCertManager(d); err != nil {
-		return err
-	}
-	fmt.Println(header("Creating Vault resources..."))
-	if err := makeVaultResources(d); err != nil {
-		return err
-	}
-	fmt.Println(header("Setting up TLS certificates..."))
-	if err := makeCertificates(d); err != nil {
-		return err
-	}
-	fmt.Println(header("Initializing Vault..."))
-	rootKey, recoveryKeys, err := initVault(d)
-	if err != nil {
-		return err
-	}
-	if globalVaultFlags.KeysOutputFile != "" {
-		if err := saveKeysToFile(
-			rootKey,
-			recoveryKeys,
-			globalVaultFlags.KeysOutputFile,
-		); err != nil {
-			return err
-		}
-	}
-
-	fmt.Println(header("Initializing Cert-Watcher..."))
-	if err := initCertWatcher(d); err != nil {
-		return err
-	}
-
-	if globalVaultFlags.Auth != "" {
-		fmt.Println(header("Setting up Vault authentication..."))
-		if err := setupVaultAuth(d, rootKey); err != nil {
-			return err
-		}
-	}
-
-	fmt.Println(header("Port forwarding Vault..."))
-	if signInURI, err := portForwardVaultUI(d); err != nil {
-		return err
-	} else {
-		fmt.Printf("Vault UI available at \x1b[34m%s\x1b[0m\n", signInURI)
+// vaultSteps are the steps that deploy Vault to a cluster prepared by the
+// deploySteps.
+func vaultSteps(d dispatch.ClusterDispatcher) []pipeline.Step {
+	// The root token is set by the init step for the steps after it.
+	var rootKey string
+	return []pipeline.Step{
+		{
+			Name:  "dependencies",
+			Title: "Installing Dependencies...",
+			Deps:  []string{"wait_ready"},
+			Run:   func() error { return installDependencies(d) },
+		},
+		{
+			Name:  "cert_manager",
+			Title: "Installing Cert-Manager...",
+			Deps:  []string{"k3s", "dependencies"},
+			Run:   func() error { return initCertManager(d) },
+			Undo:  func() error { return uninstallReleases(d, certManagerReleases...) },
+		},
+		{
+			Name:  "vault_resources",
+			Title: "Creating Vault resources...",
+			Deps:  []string{"k3s", "dependencies"},
+			Run:   func() error { return makeVaultResources(d) },
+			Undo: func() error {
+				if err := sendKubeCommands(
+					d,
+					withManifest("kubectl delete --ignore-not-found -f - <<'EOF'", vaultManifest),
+					"kubectl delete secret kms -n vault --ignore-not-found",
+				); err != nil {
+					return err
+				}
+				return removeVaultStorage(d)
+			},
+		},
+		{
+			Name:  "certificates",
+			Title: "Setting up TLS certificates...",
+			Deps:  []string{"cert_manager", "vault_resources"},
+			Run:   func() error { return makeCertificates(d) },
+			Undo: func() error {
+				return sendKubeCommands(
+					d,
+					withManifest("kubectl delete --ignore-not-found -f - <<'EOF'", trustBundleManifest),
+					"kubectl delete configmap -n cert-manager tls-ca expiring-tls-ca --ignore-not-found",
+					withManifest("kubectl delete --ignore-not-found -f - <<'EOF'", certificatesManifest),
+				)
+			},
+		},
+		{
+			Name:  "init",
+			Title: "Initializing Vault...",
+			Deps:  []string{"certificates"},
+			Run: func() error {
+				var recoveryKeys []string
+				var err error
+				if rootKey, recoveryKeys, err = initVault(d); err != nil {
+					return err
+				}
+				if globalVaultFlags.KeysOutputFile == "" {
+					return nil
+				} else if globalDryRun {
+					printInfo("Would save Vault keys to %s.", globalVaultFlags.KeysOutputFile)
+					return nil
+				} else if globalVaultFlags.ExportScripts != "" {
+					printInfo("Vault keys are printed by the master node's script and not saved to %s.",
+						globalVaultFlags.KeysOutputFile)
+					return nil
+				}
+				return saveKeysToFile(rootKey, recoveryKeys, globalVaultFlags.KeysOutputFile)
+			},
+			// Vault's data is removed with its storage when the vault resources are
+			// undone.
+			Undo: func() error { return uninstallReleases(d, vaultRelease) },
+		},
+		{
+			Name:  "cert_watcher",
+			Title: "Initializing Cert-Watcher...",
+			Deps:  []string{"init"},
+			Run:   func() error { return initCertWatcher(d) },
+			Undo: func() error {
+				return sendKubeCommands(
+					d,
+					withManifest("kubectl delete --ignore-not-found -f - <<'EOF'", certWatcherManifest),
+					"kubectl delete configmap -n vault cert-watcher-script --ignore-not-found",
+				)
+			},
+		},
+		{
+			Name:    "auth",
+			Title:   "Setting up Vault authentication...",
+			Deps:    []string{"init"},
+			Enabled: func() bool { return globalVaultFlags.Auth != VAULT_AUTH_NONE },
+			Run: func() error {
+				if rootKey == "" && globalVaultFlags.KeysOutputFile != "" {
+					// Vault was initialized by a previous deployment.
+					keys, err := loadKeysFromFile(globalVaultFlags.KeysOutputFile)
+					if err != nil {
+						return err
+					}
+					rootKey = keys.RootKey
+				}
+				if rootKey == "" {
+					return errors.New("the Vault root token is only known when the init step runs, " +
+						"or from the keys of a previous deployment in --keys_output_file")
+				}
+				return setupVaultAuth(d, rootKey)
+			},
+		},
+		{
+			Name:  "port_forward",
+			Title: "Port forwarding Vault...",
+			Deps:  []string{"init"},
+			Run: func() error {
+				signInURI, err := portForwardVaultUI(d)
+				if err != nil {
+					return err
+				}
+				deployState.SetOutput(vaultURLOutput, signInURI)
+				printInfo("Vault UI available at %s", dispatch.Output.Highlight(signInURI, "34"))
+				return nil
+			},
+			Undo: func() error {
+				return sendKubeCommands(d, `pkill -f "kubectl port-forward -n vault svc/vault" || true`)
+			},
+		},
 	}
-	return nil
 }
 
 // installHelm installs helm on the master node in order to install the
@@ -211,7 +331,7 @@ func installHelm(d dispatch.ClusterDispatcher) error {
 		d, d.GetMasterNode(), dispatch.NewCommand("helm version"), nil,
 	)
 	if installed {
-		fmt.Println("Helm already installed.")
+		printInfo("Helm already installed.")
 		return nil
 	}
 	if err != nil {
@@ -236,6 +356,9 @@ func installHelm(d dispatch.ClusterDispatcher) error {
 	return nil
 }
 
+// certManagerVersion is the version of the cert-manager chart that is installed.
+const certManagerVersion = "v1.16.1"
+
 // initCertManager initializes cert-manager on the cluster to manage the
 // signing and auto-rotating of certificates for the vault server.
 func initCertManager(d dispatch.ClusterDispatcher) error {
@@ -249,7 +372,7 @@ func initCertManager(d dispatch.ClusterDispatcher) error {
 					"cert-manager jetstack/cert-manager " +
 					"--namespace cert-manager " +
 					"--create-namespace " +
-					"--version v1.16.1 " +
+					"--version " + certManagerVersion + " " +
 					"--set disableAutoApproval=true " +
 					"--set crds.enabled=true",
 			},
@@ -302,18 +425,20 @@ func initCertManager(d dispatch.ClusterDispatcher) error {
 // makeVaultResources creates the K8s resources required by the vault server.
 func makeVaultResources(d dispatch.ClusterDispatcher) error {
 	var wg errgroup.Group
+	progress := dispatch.NewProgressRenderer(os.Stdout, d.GetNodes())
 	for _, node := range d.GetNodes() {
 		wg.Go(func() error {
 			if ret := d.SendCommands(
 				node,
 				dispatch.NewCommand(
 					"sudo mkdir -p /srv/cluster/storage/vault",
-					dispatch.WithOsPipe(),
-					dispatch.WithPrefixWriter(node),
+					dispatch.WithProgress(progress, node),
 				),
 			); ret != nil {
+				progress.SetStatus(node, fmt.Sprintf("Error creating vault directory: %v", ret))
 				return ret
 			}
+			progress.SetStatus(node, "Vault directory created.")
 			return nil
 		})
 	}
@@ -326,7 +451,7 @@ func makeVaultResources(d dispatch.ClusterDispatcher) error {
 	if err := d.SendFile(
 		master,
 		creds,
-		"~/projects/log-console/credentials.json",
+		"~/"+kmsCredentials,
 	); err != nil {
 		return fmt.Errorf("error sending credentials file to master node: %w", err)
 	}
@@ -335,11 +460,12 @@ func makeVaultResources(d dispatch.ClusterDispatcher) error {
 		master,
 		dispatch.NewCommands(
 			[]string{
-				"kubectl apply -f ~/projects/log-console/k8s/vault/vault.yaml",
+				withManifest("kubectl apply -f - <<'EOF'", vaultManifest),
 				"kubectl create secret generic kms -n vault " +
-					"--from-file ~/projects/log-console/credentials.json --dry-run=client -o json | " +
+					"--from-file credentials.json=$HOME/" + kmsCredentials + " --dry-run=client -o json | " +
 					`jq '.metadata += {"labels":{"app":"vault"}}' | ` +
 					"kubectl apply -f -",
+				"rm -f ~/" + kmsCredentials,
 			},
 			dispatch.WithEnv(kubeEnv),
 			dispatch.WithOsPipe(),
@@ -359,7 +485,7 @@ func makeCertificates(d dispatch.ClusterDispatcher) error {
 	if err := d.SendCommands(
 		master,
 		dispatch.NewCommand(
-			"kubectl apply -f ~/projects/log-console/k8s/vault/certificates.yaml",
+			withManifest("kubectl apply -f - <<'EOF'", certificatesManifest),
 			dispatch.WithEnv(kubeEnv),
 			dispatch.WithOsPipe(),
 			dispatch.WithPrefixWriter(master),
@@ -374,13 +500,14 @@ func makeCertificates(d dispatch.ClusterDispatcher) error {
 		dispatch.NewCommand(
 			"kubectl get -n vault secrets tls-ca -o go-template='{{index .data \"tls.crt\"}}' | base64 -d",
 			dispatch.WithEnv(kubeEnv),
-			dispatch.WithStderr(dispatch.NewPrefixWriter(master.Name, os.Stderr)),
+			dispatch.WithStderr(dispatch.Output.Writer(master.Name, os.Stderr)),
 			dispatch.WithStdout(&caCertBuf),
 		),
 	); err != nil {
 		return fmt.Errorf("error getting CA certificate: %w", err)
 	}
 	caCert := strings.TrimSpace(caCertBuf.String())
+	deployState.SetOutput(caFingerprintOutput, fingerprint(caCert))
 
 	// Write CA Cert to configMap to be used by trust bundle
 	// https://github.com/SgtCoDFish/rotate-roots/tree/main/01-initial-private-pki#handling-trust
@@ -411,7 +538,7 @@ func makeCertificates(d dispatch.ClusterDispatcher) error {
 	if err := d.SendCommands(
 		master,
 		dispatch.NewCommand(
-			"kubectl apply -f ~/projects/log-console/k8s/vault/trust-bundle.yaml",
+			withManifest("kubectl apply -f - <<'EOF'", trustBundleManifest),
 			dispatch.WithEnv(kubeEnv),
 			dispatch.WithOsPipe(),
 		),
@@ -434,9 +561,7 @@ func initVault(d dispatch.ClusterDispatcher) (
 			[]string{
 				"helm repo add hashicorp https://helm.releases.hashicorp.com",
 				"helm repo update",
-				"helm install vault hashicorp/vault " +
-					"-f ~/projects/log-console/k8s/vault/vault-overrides.yaml " +
-					"--namespace vault",
+				withManifest("helm install vault hashicorp/vault -f - --namespace vault <<'EOF'", vaultOverrides),
 			},
 			dispatch.WithEnv(kubeEnv),
 			dispatch.WithOsPipe(),
@@ -446,13 +571,14 @@ func initVault(d dispatch.ClusterDispatcher) (
 		return "", nil, fmt.Errorf("error initializing vault: %w", err)
 	}
 
-	fmt.Println("Waiting for vault pod to be running...")
+	printInfo("Waiting for vault pod to be running...")
 	if err := waitVaultPods(d); err != nil {
 		return "", nil, err
 	}
-	fmt.Println("Vault pods running. Initializing vault...")
+	printInfo("Vault pods running. Initializing vault...")
 
-	stdout := dispatch.NewPrefixWriter(master.Name, os.Stdout)
+	stdout := dispatch.Output.Writer(master.Name, os.Stdout)
+	defer stdout.Flush()
 	recoveryKeysPattern := regexp.MustCompile(`Recovery Key \d+: (.+)\n`)
 	recoveryKeysPipe := newCapturingPipe(stdout, 100, func(b []byte) ([]byte, bool) {
 		if match := recoveryKeysPattern.FindSubmatch(b); match != nil {
@@ -475,24 +601,31 @@ func initVault(d dispatch.ClusterDispatcher) (
 				`"export VAULT_SKIP_VERIFY=1; vault operator init"`,
 			dispatch.WithEnv(kubeEnv),
 			dispatch.WithStdout(rootKeyPipe),
-			dispatch.WithStderr(dispatch.NewPrefixWriter(master.Name, os.Stderr)),
+			dispatch.WithStderr(dispatch.Output.Writer(master.Name, os.Stderr)),
 		),
 	); err != nil {
 		return "", nil, fmt.Errorf("error initializing vault: %w", err)
 	}
 
+	if len(rootKeyPipe.Captured) == 0 {
+		return "", nil, errors.New("could not find the root token in the vault init output")
+	}
 	recoveryKeys = sliceutils.Map(recoveryKeysPipe.Captured, func(b []byte, _ int) string {
+		transcript.AddSecret(string(b))
 		return string(b)
 	})
+	transcript.AddSecret(string(rootKeyPipe.Captured[0]))
 	return string(rootKeyPipe.Captured[0]), recoveryKeys, nil
 }
 
+// vaultKeys are the keys Vault is initialized with.
+type vaultKeys struct {
+	RootKey      string   `json:"root_key"`
+	RecoveryKeys []string `json:"recovery_keys"`
+}
+
 func saveKeysToFile(rootKey string, recoveryKeys []string, filePath string) error {
-	type keys struct {
-		RootKey      string   `json:"root_key"`
-		RecoveryKeys []string `json:"recovery_keys"`
-	}
-	k := keys{
+	k := vaultKeys{
 		RootKey:      rootKey,
 		RecoveryKeys: recoveryKeys,
 	}
@@ -504,6 +637,23 @@ func saveKeysToFile(rootKey string, recoveryKeys []string, filePath string) erro
 	return os.WriteFile(filePath, jsonBytes, 0644)
 }
 
+// loadKeysFromFile loads the keys saved by saveKeysToFile.
+func loadKeysFromFile(filePath string) (vaultKeys, error) {
+	var k vaultKeys
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return k, fmt.Errorf("error reading Vault keys: %w", err)
+	}
+	if err := json.Unmarshal(b, &k); err != nil {
+		return k, fmt.Errorf("error reading Vault keys: %w", err)
+	}
+	transcript.AddSecret(k.RootKey)
+	for _, key := range k.RecoveryKeys {
+		transcript.AddSecret(key)
+	}
+	return k, nil
+}
+
 func waitVaultPods(d dispatch.ClusterDispatcher) error {
 	// Get all vault pod names
 	var output bytes.Buffer
@@ -513,7 +663,7 @@ func waitVaultPods(d dispatch.ClusterDispatcher) error {
 			`kubectl get pods -n vault --template `+
 				`'{{range .items}}{{.metadata.name}}{{"\n"}}{{end}}' | grep "^vault-[0-9]\+"`,
 			dispatch.WithStdout(&output),
-			dispatch.WithStderr(dispatch.NewPrefixWriter(
+			dispatch.WithStderr(dispatch.Output.Writer(
 				d.GetMasterNode().Name,
 				os.Stderr,
 			)),
@@ -551,11 +701,13 @@ func initCertWatcher(d dispatch.ClusterDispatcher) error {
 		master,
 		dispatch.NewCommands(
 			[]string{
-				"kubectl create configmap -n vault cert-watcher-script " +
-					"--from-file=watcher.sh=$HOME/projects/log-console/k8s/vault/cert-watcher.sh " +
-					`--dry-run=client -o json | jq '.metadata += {"labels":{"app":"vault"}}' | ` +
-					"kubectl apply -f -",
-				"kubectl apply -f ~/projects/log-console/k8s/vault/cert-watcher.yaml",
+				withManifest(
+					"kubectl create configmap -n vault cert-watcher-script --from-file=watcher.sh=/dev/stdin "+
+						`--dry-run=client -o json <<'EOF' | jq '.metadata += {"labels":{"app":"vault"}}' | `+
+						"kubectl apply -f -",
+					certWatcherScript,
+				),
+				withManifest("kubectl apply -f - <<'EOF'", certWatcherManifest),
 			},
 			dispatch.WithEnv(kubeEnv),
 			dispatch.WithOsPipe(),
@@ -582,7 +734,7 @@ func portForwardVaultUI(d dispatch.ClusterDispatcher) (string, error) {
 		return "", fmt.Errorf("error port forwarding: %w", err)
 	}
 
-	return globalVaultFlags.Auth.SignInURI(master.Remote.FQDN)
+	return globalVaultFlags.Auth.SignInURI(master.Address())
 }
 
 func setupVaultAuth(d dispatch.ClusterDispatcher, rootToken string) error {
@@ -617,6 +769,7 @@ func (e *vaultAuth) doGithubAuth(d dispatch.ClusterDispatcher, rootToken string)
 	}
 	fmt.Println()
 	pat = string(patBytes)
+	transcript.AddSecret(pat)
 
 	return d.SendCommands(
 		d.GetMasterNode(),
@@ -645,6 +798,7 @@ func (e *vaultAuth) doUserpassAuth(d dispatch.ClusterDispatcher, rootToken strin
 	}
 	fmt.Println()
 	password = string(passwordBytes)
+	transcript.AddSecret(password)
 
 	return d.SendCommands(
 		d.GetMasterNode(),
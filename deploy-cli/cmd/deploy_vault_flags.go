@@ -24,6 +24,11 @@ func (f *vaultFlags) validate() error {
 	if _, err := os.Stat(creds); os.IsNotExist(err) {
 		return errors.New("Cloud credentials file does not exist.")
 	}
+	// Auth needs the root token, which is only known once the script runs, and
+	// would write the credentials entered into the scripts.
+	if f.ExportScripts != "" && f.Auth != VAULT_AUTH_NONE {
+		return errors.New("Vault auth cannot be set up when exporting scripts.")
+	}
 	return nil
 }
 
@@ -3,11 +3,13 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"regexp"
 
 	"github.com/kev-cao/log-console/deploy-cli/dispatch"
 	"github.com/kev-cao/log-console/deploy-cli/dispatch/multipass"
 	"github.com/kev-cao/log-console/deploy-cli/dispatch/ssh"
 	"github.com/kev-cao/log-console/utils/sliceutils"
+	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
 
@@ -48,43 +50,148 @@ func (m *dispatchMethod) Type() string {
 	return "method"
 }
 
+// clusterName is the name of a multipass cluster, used to prefix the names of
+// its VMs.
+type clusterName string
+
+var _ pflag.Value = (*clusterName)(nil)
+var clusterNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]*$`)
+
+func (c *clusterName) String() string {
+	return string(*c)
+}
+
+func (c *clusterName) Set(s string) error {
+	if !clusterNamePattern.MatchString(s) {
+		return errors.New("must start with a letter and only contain letters, digits and hyphens")
+	}
+	*c = clusterName(s)
+	return nil
+}
+
+func (c *clusterName) Type() string {
+	return "name"
+}
+
+// addClusterNameFlag registers the cluster name flag on the flag set.
+func addClusterNameFlag(flags *pflag.FlagSet, c *clusterName) {
+	flags.VarP(
+		c,
+		"cluster",
+		"c",
+		"Name of the multipass cluster, used to prefix its VM names (e.g. <cluster>-master) "+
+			"so that multiple clusters can run side by side.",
+	)
+}
+
+// addRemoteFlags registers the flags that set the SSH remotes to connect to and
+// how on the flag set.
+func addRemoteFlags(flags *pflag.FlagSet, remotes *[]string, identityFile *string, jumpHosts *[]string) {
+	flags.StringSliceVarP(
+		remotes,
+		"remotes",
+		"r",
+		nil,
+		"User-qualified hostnames or ~/.ssh/config aliases for each remote node (required for SSH deployments). "+
+			"First address is the master node.",
+	)
+	flags.StringVarP(
+		identityFile,
+		"identity_file",
+		"i",
+		"",
+		"Optional identity (private key) file to use for SSH deployments. "+
+			"SSH agent keys and identity files from ~/.ssh/config are also used.",
+	)
+	flags.StringSliceVarP(
+		jumpHosts,
+		"jump",
+		"J",
+		nil,
+		"User-qualified hostnames, optionally with a port, of jump hosts to tunnel through for SSH deployments, in hop order. "+
+			"The jump hosts apply to every node. To jump differently for some nodes, set a ProxyJump "+
+			"(or ProxyJump none) for them in ~/.ssh/config, which takes precedence.",
+	)
+}
+
+// addAllowUnreachableFlag registers the allow unreachable flag on the flag set.
+// unreachable describes what the command does with the unreachable nodes.
+func addAllowUnreachableFlag(flags *pflag.FlagSet, allowUnreachable *bool, unreachable string) {
+	flags.BoolVar(
+		allowUnreachable,
+		"allow_unreachable",
+		false,
+		"Continue with the reachable nodes if some SSH remotes cannot be connected to. "+unreachable,
+	)
+}
+
 type dispatcherFactory struct {
 	// Cached dispatchers
-	mp  *multipass.MultipassDispatcher
-	ssh *ssh.SshDispatcher
+	mp      *multipass.MultipassDispatcher
+	ssh     *ssh.SshDispatcher
+	dryRun  *dispatch.DryRunDispatcher
+	scripts *dispatch.ScriptDispatcher
 }
 
+// globalDryRun makes every dispatcher print the actions it would carry out
+// instead of carrying them out.
+var globalDryRun bool
+
 var dispatchers dispatcherFactory = dispatcherFactory{}
 
 // GetDispatcher returns a dispatcher based on the deployment method. Flags are dependent on the
-// deployment method.
+// deployment method. For dry runs, the dispatcher is wrapped in a DryRunDispatcher, and
+// for deployments exported to scripts, in a ScriptDispatcher.
 func (f *dispatcherFactory) GetDispatcher(
 	flags map[string]interface{}, method dispatchMethod,
+) (dispatch.ClusterDispatcher, error) {
+	if globalDryRun {
+		return f.getDryRunDispatcher(flags, method)
+	}
+	if dir, _ := flags["ExportScripts"].(string); dir != "" || f.scripts != nil {
+		return f.getScriptDispatcher(flags, method, dir)
+	}
+	return f.getDispatcher(flags, method)
+}
+
+func (f *dispatcherFactory) getDispatcher(
+	flags map[string]interface{}, method dispatchMethod,
 ) (dispatch.ClusterDispatcher, error) {
 	switch method {
 	case MULTIPASS:
 		if f.mp == nil {
-			f.mp = multipass.NewMultipassDispatcher(flags["NumNodes"].(int), "master", "worker")
+			mp, err := multipass.NewMultipassDispatcher(
+				flags["NumNodes"].(int),
+				string(flags["ClusterName"].(clusterName)),
+				"master",
+				"worker",
+			)
+			if err != nil {
+				return nil, err
+			}
+			f.mp = mp
+			if opts, ok := flags["LaunchOptions"].(launchFlags); ok {
+				if err := opts.apply(f.mp); err != nil {
+					return nil, err
+				}
+			}
 		}
 		return f.mp, nil
 	case SSH:
 		if f.ssh == nil {
-			remotes, err := sliceutils.MapErr(
-				flags["Remotes"].([]string),
-				func(s string, _ int) (dispatch.UserQualifiedHostname, error) {
-					var uqhn dispatch.UserQualifiedHostname
-					if _, err := uqhn.ParseString(s); err != nil {
-						return dispatch.UserQualifiedHostname{}, err
-					}
-					return uqhn, nil
-				},
-			)
+			remotes, err := parseRemotes(flags["Remotes"].([]string))
+			if err != nil {
+				return nil, err
+			}
+			jumpHosts, err := parseRemotes(flags["JumpHosts"].([]string))
 			if err != nil {
 				return nil, err
 			}
 			if f.ssh, err = ssh.NewSshDispatcher(
 				remotes,
 				flags["IdentityFile"].(string),
+				jumpHosts,
+				flags["AllowUnreachable"].(bool),
 			); err != nil {
 				return nil, err
 			}
@@ -94,3 +201,98 @@ func (f *dispatcherFactory) GetDispatcher(
 		return nil, fmt.Errorf("Unknown deployment method: %s", method)
 	}
 }
+
+// getDryRunDispatcher returns a DryRunDispatcher for the deployment method. SSH
+// remotes aren't connected to, since only their nodes are needed.
+func (f *dispatcherFactory) getDryRunDispatcher(
+	flags map[string]interface{}, method dispatchMethod,
+) (dispatch.ClusterDispatcher, error) {
+	if f.dryRun != nil {
+		return f.dryRun, nil
+	}
+	var d dispatch.ClusterDispatcher
+	switch method {
+	case SSH:
+		remotes, err := parseRemotes(flags["Remotes"].([]string))
+		if err != nil {
+			return nil, err
+		}
+		d = &ssh.SshDispatcher{NumNodes: len(remotes), Remotes: remotes}
+	default:
+		var err error
+		if d, err = f.getDispatcher(flags, method); err != nil {
+			return nil, err
+		}
+	}
+	f.dryRun = dispatch.NewDryRunDispatcher(d)
+	// Output that is parsed during a deployment, so that the rest of it can be
+	// printed too.
+	f.dryRun.Script(`node-token`, "<k3s-token>\n")
+	f.dryRun.Script(`grep "\^vault-`, "vault-0\n")
+	f.dryRun.Script(`vault operator init`, "Recovery Key 1: <recovery-key>\nInitial Root Token: <root-token>\n")
+	return f.dryRun, nil
+}
+
+// getScriptDispatcher returns a ScriptDispatcher that writes the scripts to dir.
+// Like dry runs, SSH remotes aren't connected to.
+func (f *dispatcherFactory) getScriptDispatcher(
+	flags map[string]interface{}, method dispatchMethod, dir string,
+) (dispatch.ClusterDispatcher, error) {
+	if f.scripts != nil {
+		return f.scripts, nil
+	}
+	var d dispatch.ClusterDispatcher
+	switch method {
+	case SSH:
+		remotes, err := parseRemotes(flags["Remotes"].([]string))
+		if err != nil {
+			return nil, err
+		}
+		d = &ssh.SshDispatcher{NumNodes: len(remotes), Remotes: remotes}
+	default:
+		var err error
+		if d, err = f.getDispatcher(flags, method); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if f.scripts, err = dispatch.NewScriptDispatcher(d, dir); err != nil {
+		return nil, err
+	}
+	// Output that is parsed during a deployment. Output read on the same node is
+	// captured when the script runs, and output read on another node must be
+	// passed in through the environment.
+	f.scripts.Script(`node-token`, "${K3S_TOKEN}\n")
+	f.scripts.Script(`grep "\^vault-`, "vault-0\n")
+	f.scripts.Capture(`secrets tls-ca`, "TLS_CA", "${TLS_CA}\n")
+	// The keys are printed when the script runs, and aren't used by later commands.
+	f.scripts.Script(`vault operator init`, "Initial Root Token: <root-token>\n")
+	// Checks for whether something is already installed. The scripts install
+	// everything, since installing is idempotent.
+	for _, dep := range pkgDependencies {
+		f.scripts.Skip(regexp.QuoteMeta(dep.checkCmd), 127)
+	}
+	f.scripts.Skip(`systemctl is-active k3s`, 0)
+	return f.scripts, nil
+}
+
+// rejectDryRun fails commands that don't support dry runs.
+func rejectDryRun(cmd *cobra.Command, _ []string) {
+	if globalDryRun {
+		checkErr(fmt.Errorf("--dry_run is not supported by %s.", cmd.CommandPath()))
+	}
+}
+
+// parseRemotes parses a list of user-qualified hostnames.
+func parseRemotes(remotes []string) ([]dispatch.UserQualifiedHostname, error) {
+	return sliceutils.MapErr(
+		remotes,
+		func(s string, _ int) (dispatch.UserQualifiedHostname, error) {
+			var uqhn dispatch.UserQualifiedHostname
+			if _, err := uqhn.ParseString(s); err != nil {
+				return dispatch.UserQualifiedHostname{}, err
+			}
+			return uqhn, nil
+		},
+	)
+}
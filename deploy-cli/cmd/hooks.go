@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/pipeline"
+	"github.com/kev-cao/log-console/utils/pathutils"
+	"github.com/kev-cao/log-console/utils/sliceutils"
+	"github.com/kev-cao/log-console/utils/stringutils"
+)
+
+// hookPhase is when a hook runs relative to its step.
+type hookPhase string
+
+const (
+	hookBefore hookPhase = "before"
+	hookAfter  hookPhase = "after"
+)
+
+// Where hooks run.
+const (
+	hookLocal   = "local"
+	hookMaster  = "master"
+	hookWorkers = "workers"
+	hookAll     = "all"
+)
+
+// hook is a command or script the user has configured to run before or after a
+// deploy or teardown step, e.g. to register the Vault URL in DNS after the
+// port_forward step. For example:
+//
+//	hooks:
+//	  - step: port_forward
+//	    when: after
+//	    run: ./register-dns.sh "$DEPLOY_CLI_OUTPUT_VAULT_URL"
+//	  - step: teardown_k3s
+//	    when: before
+//	    on: master
+//	    script: ~/hooks/backup-etcd.sh
+type hook struct {
+	Step string    `yaml:"step"`
+	When hookPhase `yaml:"when"`
+	// On is where the hook runs: local, on the deploying machine, which is the
+	// default, or on the master, workers or all nodes.
+	On string `yaml:"on"`
+	// Run is a bash command to run.
+	Run string `yaml:"run"`
+	// Script is an executable on the deploying machine to run instead of a
+	// command. It's copied to the nodes to run it on them.
+	Script string `yaml:"script"`
+}
+
+func (h hook) validate() error {
+	if steps := hookSteps(); !slices.Contains(steps, h.Step) {
+		return fmt.Errorf("unknown step %q. Steps: %v", h.Step, steps)
+	}
+	if h.When != hookBefore && h.When != hookAfter {
+		return fmt.Errorf("when must be %q or %q, not %q", hookBefore, hookAfter, h.When)
+	}
+	if targets := []string{hookLocal, hookMaster, hookWorkers, hookAll}; h.On != "" && !slices.Contains(targets, h.On) {
+		return fmt.Errorf("on must be one of %v, not %q", targets, h.On)
+	}
+	if (h.Run == "") == (h.Script == "") {
+		return errors.New("exactly one of run and script must be set")
+	}
+	return nil
+}
+
+func (h hook) String() string {
+	target := h.On
+	if target == "" {
+		target = hookLocal
+	}
+	command := h.Script
+	if command == "" {
+		command, _, _ = strings.Cut(strings.TrimSpace(h.Run), "\n")
+	}
+	return fmt.Sprintf("%s %s on %s: %s", h.When, h.Step, target, command)
+}
+
+// hookSteps returns the names of the steps hooks can be configured for.
+func hookSteps() []string {
+	steps := append(deploySteps(nil), vaultSteps(nil)...)
+	steps = append(steps, teardownStep(nil), teardownVaultStep(nil), teardownK3sStep(nil))
+	return sliceutils.Map(steps, func(step pipeline.Step, _ int) string { return step.Name })
+}
+
+// addHooks makes a pipeline run the configured hooks of its steps.
+func addHooks(p *pipeline.Pipeline, d dispatch.ClusterDispatcher) {
+	if len(globalConfig.Hooks) == 0 {
+		return
+	}
+	p.Before = func(step pipeline.Step) error {
+		return runHooks(d, step, hookBefore)
+	}
+	p.After = func(step pipeline.Step) error {
+		return runHooks(d, step, hookAfter)
+	}
+}
+
+// runHooks runs the hooks of a step for a phase in the order they're
+// configured, stopping at the first that fails.
+func runHooks(d dispatch.ClusterDispatcher, step pipeline.Step, when hookPhase) error {
+	for _, h := range globalConfig.Hooks {
+		if h.Step != step.Name || h.When != when {
+			continue
+		}
+		printInfo("Running hook %s", h)
+		if err := runHook(d, h, hookEnv(d, step, when)); err != nil {
+			return fmt.Errorf("error running hook %s: %w", h, err)
+		}
+	}
+	return nil
+}
+
+// hookEnv returns the environment hooks are run with, which describes the
+// cluster and the step, and has the outputs of the deployment steps so far as
+// DEPLOY_CLI_OUTPUT_<NAME>, e.g. DEPLOY_CLI_OUTPUT_VAULT_URL.
+func hookEnv(d dispatch.ClusterDispatcher, step pipeline.Step, when hookPhase) map[string]string {
+	master := d.GetMasterNode()
+	nodeNames := func(nodes []dispatch.Node) string {
+		return strings.Join(sliceutils.Map(nodes, func(node dispatch.Node, _ int) string { return node.Name }), " ")
+	}
+	env := map[string]string{
+		"DEPLOY_CLI_STEP":        step.Name,
+		"DEPLOY_CLI_HOOK":        string(when),
+		"DEPLOY_CLI_MASTER":      master.Name,
+		"DEPLOY_CLI_MASTER_FQDN": master.Address(),
+		"DEPLOY_CLI_NODES":       nodeNames(d.GetNodes()),
+		"DEPLOY_CLI_WORKERS":     nodeNames(d.GetWorkerNodes()),
+	}
+	for key, value := range deployState.CopyOutputs() {
+		// The K3S token is left out so that it isn't printed by dry runs or
+		// written to exported scripts.
+		if key == k3sTokenOutput {
+			continue
+		}
+		env["DEPLOY_CLI_OUTPUT_"+strings.ToUpper(key)] = value
+	}
+	return env
+}
+
+func runHook(d dispatch.ClusterDispatcher, h hook, env map[string]string) error {
+	script := h.Script
+	if script != "" {
+		var err error
+		if script, err = pathutils.AbsolutePath(script); err != nil {
+			return err
+		}
+	}
+	var nodes []dispatch.Node
+	switch h.On {
+	case "", hookLocal:
+		return runLocalHook(h, script, env)
+	case hookMaster:
+		nodes = []dispatch.Node{d.GetMasterNode()}
+	case hookWorkers:
+		nodes = d.GetWorkerNodes()
+	case hookAll:
+		nodes = d.GetNodes()
+	}
+	for _, node := range nodes {
+		// Environment bindings only apply to the first command of a command
+		// line, so the hook is run by a shell that has them.
+		command := h.Run
+		if script != "" {
+			dst := "/tmp/deploy-cli-hook-" + filepath.Base(script)
+			if err := d.SendFile(node, script, dst); err != nil {
+				return fmt.Errorf("error copying script to %s: %w", node.Name, err)
+			}
+			command = fmt.Sprintf("chmod +x %[1]s && %[1]s", dst)
+		}
+		if err := d.SendCommands(
+			node,
+			dispatch.NewCommand(
+				"bash -c "+stringutils.ShellQuote(command),
+				dispatch.WithEnv(env),
+				dispatch.WithOsPipe(),
+				dispatch.WithPrefixWriter(node),
+			),
+		); err != nil {
+			return fmt.Errorf("error on %s: %w", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// runLocalHook runs a hook on the deploying machine, recording it to the
+// transcript like the commands run on nodes.
+func runLocalHook(h hook, script string, env map[string]string) error {
+	if globalDryRun {
+		printInfo("Not running the local hook in a dry run.")
+		return nil
+	}
+	if dispatchers.scripts != nil {
+		printInfo("The local hook is not part of the exported scripts.")
+		return nil
+	}
+	node := dispatch.Node{Name: hookLocal}
+	command, name, args := h.Run, "bash", []string{"-c", h.Run}
+	if script != "" {
+		command, name, args = script, script, nil
+	}
+	cmd := dispatch.NewCommand(
+		command,
+		dispatch.WithEnv(env),
+		dispatch.WithOsPipe(),
+		dispatch.WithPrefixWriter(node),
+	)
+	return dispatch.RunCommand(node, cmd, func(stdout, stderr io.Writer) error {
+		local := exec.Command(name, args...)
+		local.Env = os.Environ()
+		for key, value := range env {
+			local.Env = append(local.Env, key+"="+value)
+		}
+		local.Stdout = stdout
+		local.Stderr = stderr
+		return local.Run()
+	})
+}
@@ -6,7 +6,10 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/dispatch/multipass"
 	"github.com/spf13/cobra"
 )
 
@@ -16,22 +19,195 @@ var multipassCmd = &cobra.Command{
 	Long: `Provides functionality for starting/stopping a multipass cluster. 
 		It manages a multipass cluster that is configured with Kubernetes and is set
 		up specifically for the log-console application.`,
+	PersistentPreRun: rejectDryRun,
 }
 
 func init() {
 	rootCmd.AddCommand(multipassCmd)
 
+	multipassCmd.PersistentFlags().IntVarP(
+		&multipassDispatcher.NumNodes,
+		"nodes",
+		"n",
+		3,
+		"Number of nodes in the multipass cluster",
+	)
+	addClusterNameFlag(
+		multipassCmd.PersistentFlags(),
+		(*clusterName)(&multipassDispatcher.ClusterName),
+	)
+
 	// Multipass subcommands
 	multipassCmd.AddCommand(launchCmd)
+	multipassCmd.AddCommand(startCmd)
+	multipassCmd.AddCommand(stopCmd)
+	multipassCmd.AddCommand(suspendCmd)
+	multipassCmd.AddCommand(restartCmd)
+	multipassCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotSaveCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	snapshotCmd.AddCommand(snapshotListCmd)
+	addLaunchFlags(launchCmd.Flags(), &globalLaunchFlags)
 }
 
+var globalLaunchFlags = newLaunchFlags()
+
 var launchCmd = &cobra.Command{
 	Use:   "launch",
 	Short: "Launches the multipass nodes.",
 	Long:  `Launches the multipass nodes.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := globalLaunchFlags.apply(&multipassDispatcher); err != nil {
+			checkErr(err)
+		}
 		if err := multipassDispatcher.LaunchNodes(); err != nil {
-			cobra.CheckErr(errors.New(fmt.Sprintf("Error launching multipass cluster: %v\n", err)))
+			checkErr(errors.New(fmt.Sprintf("Error launching multipass cluster: %v\n", err)))
+		}
+	},
+}
+
+var startCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Starts or resumes the multipass nodes.",
+	Long:  `Starts or resumes the multipass nodes and waits for K3S to become healthy again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		startStep("Starting nodes...")
+		if err := multipassDispatcher.Start(); err != nil {
+			checkErr(err)
+		}
+		if err := waitClusterHealthy(&multipassDispatcher); err != nil {
+			checkErr(err)
+		}
+	},
+}
+
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stops the multipass nodes.",
+	Long:  `Stops the multipass nodes without deleting them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		startStep("Stopping nodes...")
+		if err := multipassDispatcher.Stop(); err != nil {
+			checkErr(err)
+		}
+		printInfo("Nodes stopped.")
+	},
+}
+
+var suspendCmd = &cobra.Command{
+	Use:   "suspend",
+	Short: "Suspends the multipass nodes.",
+	Long:  `Suspends the multipass nodes. Use start to resume them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		startStep("Suspending nodes...")
+		if err := multipassDispatcher.Suspend(); err != nil {
+			checkErr(err)
+		}
+		printInfo("Nodes suspended.")
+	},
+}
+
+var restartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restarts the multipass nodes.",
+	Long:  `Restarts the multipass nodes and waits for K3S to become healthy again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		startStep("Restarting nodes...")
+		if err := multipassDispatcher.Restart(); err != nil {
+			checkErr(err)
+		}
+		if err := waitClusterHealthy(&multipassDispatcher); err != nil {
+			checkErr(err)
 		}
 	},
 }
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manages snapshots of the whole multipass cluster.",
+	Long: `Manages snapshots of the whole multipass cluster. Nodes are stopped before
+taking or restoring a snapshot so that every node is captured in a consistent state.`,
+}
+
+var snapshotSaveCmd = &cobra.Command{
+	Use:   "save <name>",
+	Short: "Takes a snapshot of every node in the cluster.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		startStep(fmt.Sprintf("Saving snapshot %s...", args[0]))
+		if err := saveSnapshot(&multipassDispatcher, args[0]); err != nil {
+			checkErr(err)
+		}
+		printInfo("Snapshot saved.")
+	},
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore <name>",
+	Short: "Restores every node in the cluster to a snapshot.",
+	Long:  `Restores every node in the cluster to a snapshot. The current state of the nodes is discarded.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		startStep(fmt.Sprintf("Restoring snapshot %s...", args[0]))
+		if err := restoreSnapshot(&multipassDispatcher, args[0]); err != nil {
+			checkErr(err)
+		}
+		printInfo("Snapshot restored.")
+	},
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the snapshots of the cluster.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		snapshots, err := multipassDispatcher.ListSnapshots()
+		if err != nil {
+			checkErr(err)
+		}
+		if len(snapshots) == 0 {
+			printInfo("No snapshots found.")
+			return
+		}
+		for _, snapshot := range snapshots {
+			if snapshot.Complete {
+				printInfo(snapshot.Name)
+			} else {
+				printInfo("%s (incomplete, only on %s)", snapshot.Name, strings.Join(snapshot.Nodes, ", "))
+			}
+		}
+	},
+}
+
+// saveSnapshot takes a snapshot of the cluster and waits for it to be healthy
+// again after its nodes are restarted.
+func saveSnapshot(m *multipass.MultipassDispatcher, name string) error {
+	if err := m.SaveSnapshot(name); err != nil {
+		return err
+	}
+	return waitClusterHealthy(m)
+}
+
+// restoreSnapshot restores the cluster to a snapshot and waits for it to be
+// healthy again after its nodes are restarted.
+func restoreSnapshot(m *multipass.MultipassDispatcher, name string) error {
+	if err := m.RestoreSnapshot(name); err != nil {
+		return err
+	}
+	return waitClusterHealthy(m)
+}
+
+// waitClusterHealthy waits for the nodes to be ready and then for K3S to report
+// every node as ready.
+func waitClusterHealthy(d dispatch.ClusterDispatcher) error {
+	startStep("Waiting for cluster to be ready...")
+	if err := waitReady(d); err != nil {
+		return err
+	}
+	startStep("Waiting for K3S to be healthy...")
+	if err := waitK3S(d); err != nil {
+		return err
+	}
+	printInfo("Cluster healthy.")
+	return nil
+}
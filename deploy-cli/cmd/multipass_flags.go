@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch/multipass"
+	"github.com/kev-cao/log-console/utils/pathutils"
+	"github.com/spf13/pflag"
+)
+
+// nodeSpec is a flag for a multipass node's VM configuration, given as a comma
+// separated list of key=value pairs, e.g. cpus=4,memory=4G,disk=40G,image=24.04,network=br0.
+// Keys that are not provided keep their current value.
+type nodeSpec multipass.NodeSpec
+
+var _ pflag.Value = (*nodeSpec)(nil)
+var nodeSpecKeys = []string{"cpus", "memory", "disk", "image", "network"}
+
+func (s *nodeSpec) String() string {
+	parts := []string{
+		fmt.Sprintf("cpus=%d", s.Cpus),
+		"memory=" + s.Memory,
+		"disk=" + s.Disk,
+	}
+	if s.Image != "" {
+		parts = append(parts, "image="+s.Image)
+	}
+	if s.Network != "" {
+		parts = append(parts, "network="+s.Network)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *nodeSpec) Set(v string) error {
+	for _, pair := range strings.Split(v, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || value == "" {
+			return fmt.Errorf("invalid node spec %q, must be key=value pairs with keys %v", pair, nodeSpecKeys)
+		}
+		switch key {
+		case "cpus":
+			cpus, err := strconv.Atoi(value)
+			if err != nil || cpus <= 0 {
+				return fmt.Errorf("cpus must be a positive integer, got %q", value)
+			}
+			s.Cpus = cpus
+		case "memory":
+			s.Memory = value
+		case "disk":
+			s.Disk = value
+		case "image":
+			s.Image = value
+		case "network":
+			s.Network = value
+		default:
+			return fmt.Errorf("unknown node spec key %q, must be one of %v", key, nodeSpecKeys)
+		}
+	}
+	return nil
+}
+
+func (s *nodeSpec) Type() string {
+	return "spec"
+}
+
+// launchFlags are the options for launching multipass nodes.
+type launchFlags struct {
+	MasterSpec nodeSpec
+	WorkerSpec nodeSpec
+	// CloudInit is the path to a cloud-init user-data template. Uses the default
+	// template if empty.
+	CloudInit   string
+	NoCloudInit bool
+	// AuthorizedKeys are paths to public key files to authorize on the nodes.
+	AuthorizedKeys []string
+}
+
+func newLaunchFlags() launchFlags {
+	return launchFlags{
+		MasterSpec: nodeSpec(multipass.DefaultNodeSpec),
+		WorkerSpec: nodeSpec(multipass.DefaultNodeSpec),
+	}
+}
+
+// addLaunchFlags registers the multipass launch flags on the flag set.
+func addLaunchFlags(flags *pflag.FlagSet, f *launchFlags) {
+	flags.Var(
+		&f.MasterSpec,
+		"master_spec",
+		fmt.Sprintf("VM configuration of the master node when launching multipass nodes. Keys: %v", nodeSpecKeys),
+	)
+	flags.Var(
+		&f.WorkerSpec,
+		"worker_spec",
+		fmt.Sprintf("VM configuration of the worker nodes when launching multipass nodes. Keys: %v", nodeSpecKeys),
+	)
+	flags.StringVar(
+		&f.CloudInit,
+		"cloud_init",
+		"",
+		"Path to a cloud-init user-data template used when launching multipass nodes. "+
+			"It is rendered per node with .Name, .Role and .AuthorizedKeys. Defaults to a built-in template.",
+	)
+	flags.BoolVar(
+		&f.NoCloudInit,
+		"no_cloud_init",
+		false,
+		"Launch multipass nodes without cloud-init user-data.",
+	)
+	flags.StringSliceVar(
+		&f.AuthorizedKeys,
+		"authorized_keys",
+		nil,
+		"Public key files to authorize for the ubuntu user when launching multipass nodes.",
+	)
+}
+
+// apply configures the multipass dispatcher with the launch flags.
+func (f *launchFlags) apply(m *multipass.MultipassDispatcher) error {
+	m.MasterSpec = multipass.NodeSpec(f.MasterSpec)
+	m.WorkerSpec = multipass.NodeSpec(f.WorkerSpec)
+
+	m.CloudInit = multipass.DefaultCloudInit
+	if f.NoCloudInit {
+		m.CloudInit = ""
+	} else if f.CloudInit != "" {
+		path, err := pathutils.AbsolutePath(f.CloudInit)
+		if err != nil {
+			return err
+		}
+		tmpl, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading cloud-init template: %w", err)
+		}
+		m.CloudInit = string(tmpl)
+	}
+
+	m.AuthorizedKeys = nil
+	for _, keyFile := range f.AuthorizedKeys {
+		path, err := pathutils.AbsolutePath(keyFile)
+		if err != nil {
+			return err
+		}
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading authorized key: %w", err)
+		}
+		m.AuthorizedKeys = append(m.AuthorizedKeys, strings.TrimSpace(string(key)))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch/multipass"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotWaitsForK3S(t *testing.T) {
+	bin := fakeExecutables(t)
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+	// Nodes run exec'd commands on this machine.
+	writeFakeExecutable(t, bin, "multipass", `
+case "$1" in
+	list) echo '{"errors": [], "info": {
+		"master": {"base": {"comment": "deploy-cli cluster snapshot"}},
+		"worker-1": {"base": {"comment": "deploy-cli cluster snapshot"}}
+	}}' ;;
+	info) echo "{\"info\": {\"$2\": {\"state\": \"Running\", \"ipv4\": [\"10.0.0.2\"]}}}" ;;
+	exec) shift 3; exec "$@" ;;
+esac`)
+	m, err := multipass.NewMultipassDispatcher(2, "", "master", "worker")
+	require.NoError(t, err)
+	m.Executable = filepath.Join(bin, "multipass")
+
+	for _, tc := range []struct {
+		name string
+		run  func() error
+		cmd  string
+	}{
+		{"save", func() error { return saveSnapshot(m, "vault") }, "multipass snapshot --name vault --comment deploy-cli cluster snapshot master"},
+		{"restore", func() error { return restoreSnapshot(m, "base") }, "multipass restore --destructive master.base"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, os.RemoveAll(filepath.Join(bin, "log")))
+			require.NoError(t, tc.run())
+			ran := ran(t, bin)
+			require.Contains(t, ran, tc.cmd)
+			require.Contains(t, ran, "multipass start master")
+			// K3S is waited for once the nodes are back up.
+			require.Equal(t, []string{
+				"kubectl get nodes",
+				"kubectl wait --for=condition=Ready node --all --timeout=300s",
+			}, ran[len(ran)-2:])
+		})
+	}
+}
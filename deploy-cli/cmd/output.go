@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/transcript"
+	"github.com/kev-cao/log-console/utils/pathutils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// outputFormat is the format of the CLI's output, bound to the format of the
+// shared dispatch.Output multiplexer.
+type outputFormat dispatch.OutputFormat
+
+var _ pflag.Value = (*outputFormat)(nil)
+var outputFormatOptions = []dispatch.OutputFormat{
+	dispatch.OutputText, dispatch.OutputPlain, dispatch.OutputJSON,
+}
+
+func (f *outputFormat) String() string {
+	return string(*f)
+}
+
+func (f *outputFormat) Set(s string) error {
+	for _, format := range outputFormatOptions {
+		if s == string(format) {
+			*f = outputFormat(format)
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("must be one of %v", outputFormatOptions))
+}
+
+func (f *outputFormat) Type() string {
+	return "format"
+}
+
+// currentStep is the step in progress. It's finished when the next step starts,
+// the command fails or the command returns.
+var currentStep string
+
+// startStep finishes the current step and prints the header of the next one.
+func startStep(step string) {
+	finishStep(nil)
+	currentStep = strings.TrimSuffix(step, "...")
+	slog.Debug("starting step", "step", currentStep)
+	if dispatchers.scripts != nil {
+		dispatchers.scripts.Section(currentStep)
+	}
+	if dispatch.Output.Format == dispatch.OutputJSON {
+		dispatch.Output.Emit(dispatch.Event{Type: dispatch.EventStepStarted, Step: currentStep})
+		return
+	}
+	if !dispatch.Output.Quiet {
+		fmt.Println(header(step))
+	}
+}
+
+// finishStep finishes the current step, if any, with the error it failed with.
+func finishStep(err error) {
+	if currentStep == "" {
+		return
+	}
+	event := dispatch.Event{Type: dispatch.EventStepFinished, Step: currentStep}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	dispatch.Output.Emit(event)
+	currentStep = ""
+}
+
+// checkErr is cobra.CheckErr, but fails the current step and emits an error
+// event first so that failures are reported in the json output format.
+func checkErr(err error) {
+	if err == nil {
+		return
+	}
+	finishStep(err)
+	slog.Debug("command failed", "err", err)
+	dispatch.Output.Emit(dispatch.Event{Type: dispatch.EventError, Error: err.Error()})
+	finishTranscript(err)
+	cobra.CheckErr(err)
+}
+
+// printInfo logs a message that isn't a node's output, such as the result of a
+// step, at info level.
+func printInfo(format string, args ...any) {
+	slog.Info(fmt.Sprintf(format, args...))
+}
+
+type logFlags struct {
+	Verbose bool
+	Quiet   bool
+	LogFile string
+}
+
+var globalLogFlags logFlags
+
+// logFile is the open log file, if any, closed once the command returns.
+var logFile *os.File
+
+// setupLogging opens the log file and sets the default logger.
+func setupLogging() {
+	if globalLogFlags.LogFile != "" {
+		path, err := pathutils.AbsolutePath(globalLogFlags.LogFile)
+		if err != nil {
+			checkErr(err)
+		}
+		logFile, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			checkErr(fmt.Errorf("error opening log file: %w", err))
+		}
+	}
+	setLogger()
+}
+
+// setLogger sets the default logger to write to the screen at the level set by
+// the flags, and everything to the log file and the run's transcript.
+func setLogger() {
+	level := slog.LevelInfo
+	if globalLogFlags.Verbose {
+		level = slog.LevelDebug
+	} else if globalLogFlags.Quiet {
+		level = slog.LevelWarn
+		dispatch.Output.Quiet = true
+	}
+	var files []io.Writer
+	if logFile != nil {
+		files = append(files, transcript.NewRedactingWriter(logFile))
+	}
+	if dispatch.Transcript != nil {
+		files = append(files, dispatch.Transcript.Log())
+	}
+	if len(files) > 0 {
+		dispatch.Output.SetLogFile(io.MultiWriter(files...))
+	} else {
+		dispatch.Output.SetLogFile(nil)
+	}
+	slog.SetDefault(slog.New(dispatch.NewLogHandler(dispatch.Output, level)))
+}
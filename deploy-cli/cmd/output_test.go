@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/transcript"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupLoggingLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.log")
+	globalLogFlags = logFlags{LogFile: path}
+	t.Cleanup(func() {
+		logFile.Close()
+		logFile, globalLogFlags = nil, logFlags{}
+		setLogger()
+	})
+	setupLogging()
+
+	transcript.AddSecret("s3cr3t-log-value")
+	slog.Debug("unsealed vault", "key", "s3cr3t-log-value")
+	w := dispatch.Output.Writer("master", io.Discard)
+	_, err := w.Write([]byte("Initial Root Token: hvs.abc123\n"))
+	require.NoError(t, err)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `msg="unsealed vault" key=[REDACTED]`)
+	require.Contains(t, string(b), `msg="Initial Root Token: [REDACTED]" node=master`)
+	require.NotContains(t, string(b), "s3cr3t-log-value")
+	require.NotContains(t, string(b), "hvs.abc123")
+}
@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/structs"
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/spf13/cobra"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Reports what deploying K3S and Vault would change on the cluster.",
+	Long: `Inspects the cluster and reports what "deploy vault" would change, without changing anything.
+It compares K3S on each node, the Helm releases and their chart versions, the namespaces and secrets
+the deployment creates, and whether Vault is initialized and unsealed, against what a deployment
+leaves behind.
+
+Lines are prefixed with + for resources that would be created, ~ for resources that would change
+and ? for resources that could not be inspected.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectDryRun(cmd, args)
+		if err := cmd.ValidateRequiredFlags(); err != nil {
+			checkErr(err)
+		}
+		if err := globalPlanFlags.validate(); err != nil {
+			checkErr(err)
+		}
+		dispatcher, err := dispatchers.GetDispatcher(
+			structs.Map(globalPlanFlags),
+			dispatchMethod(globalPlanFlags.Method),
+		)
+		if err != nil {
+			checkErr(err)
+		}
+		defer dispatcher.Cleanup()
+		if err := printPlan(makePlan(dispatcher)); err != nil {
+			checkErr(err)
+		}
+	},
+}
+
+var globalPlanFlags planFlags
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.Flags().VarP(
+		&globalPlanFlags.Method,
+		"method",
+		"m",
+		fmt.Sprintf("Deployment method. Options: %v", dispatchMethodOptions),
+	)
+	addClusterNameFlag(planCmd.Flags(), &globalPlanFlags.ClusterName)
+	planCmd.Flags().IntVarP(
+		&globalPlanFlags.NumNodes,
+		"nodes",
+		"n",
+		3,
+		"Number of nodes in the cluster",
+	)
+	addRemoteFlags(
+		planCmd.Flags(),
+		&globalPlanFlags.Remotes,
+		&globalPlanFlags.IdentityFile,
+		&globalPlanFlags.JumpHosts,
+	)
+	addAllowUnreachableFlag(
+		planCmd.Flags(),
+		&globalPlanFlags.AllowUnreachable,
+		"Unreachable nodes are reported as not inspected.",
+	)
+
+	planCmd.MarkFlagRequired("method")
+}
+
+type planFlags struct {
+	Method           dispatchMethod
+	ClusterName      clusterName
+	NumNodes         int
+	Remotes          []string
+	IdentityFile     string
+	JumpHosts        []string
+	AllowUnreachable bool
+}
+
+func (f *planFlags) validate() error {
+	if f.NumNodes <= 0 {
+		return errors.New("Number of nodes must be greater than 0.")
+	}
+
+	if f.Method == SSH {
+		if len(f.Remotes) == 0 {
+			return errors.New("Remote addresses must be provided for SSH deployments.")
+		} else if len(f.Remotes) != f.NumNodes {
+			return errors.New("Number of remotes must match number of nodes.")
+		}
+	}
+	return nil
+}
+
+// absent is the current state of a resource that doesn't exist.
+const absent = "absent"
+
+// planItem compares a resource on the cluster with what a deployment would
+// make it.
+type planItem struct {
+	resource string
+	current  string
+	desired  string
+	// changed is whether a deployment would change the resource.
+	changed bool
+	// err is why the resource could not be inspected.
+	err error
+}
+
+// action returns the prefix of the item in a plan.
+func (i planItem) action() string {
+	switch {
+	case i.err != nil:
+		return "?"
+	case !i.changed:
+		return " "
+	case i.current == absent:
+		return "+"
+	default:
+		return "~"
+	}
+}
+
+// newPlanItem creates an item for a resource whose current state must match
+// the desired state exactly.
+func newPlanItem(resource, current, desired string) planItem {
+	return planItem{resource: resource, current: current, desired: desired, changed: current != desired}
+}
+
+// makePlan inspects the cluster and compares it with what a deployment would
+// make it.
+func makePlan(d dispatch.ClusterDispatcher) []planItem {
+	var items []planItem
+	for _, node := range d.GetNodes() {
+		items = append(items, planK3S(d, node))
+	}
+	items = append(items, planHelmReleases(d)...)
+	items = append(items, planResources(d, "namespace", "", "cert-manager", "vault")...)
+	items = append(items, planResources(d, "secret", "vault", "kms", "tls-ca", "tls-server")...)
+	return append(items, planVault(d))
+}
+
+// inspect runs a command that inspects the cluster on a node and returns its
+// output.
+func inspect(d dispatch.ClusterDispatcher, node dispatch.Node, cmd string) (string, error) {
+	var out bytes.Buffer
+	err := d.SendCommands(
+		node,
+		dispatch.NewCommand(
+			cmd,
+			dispatch.WithEnv(kubeEnv),
+			dispatch.WithStdout(&out),
+			dispatch.WithTimeout(30*time.Second),
+		),
+	)
+	return strings.TrimSpace(out.String()), err
+}
+
+func planK3S(d dispatch.ClusterDispatcher, node dispatch.Node) planItem {
+	item := planItem{resource: fmt.Sprintf("k3s on %s", node.Name), desired: "installed"}
+	// k3s --version prints e.g. "k3s version v1.31.4+k3s1 (a562d090)".
+	version, err := inspect(d, node, `k3s --version 2>/dev/null | awk 'NR == 1 { print $3 }'`)
+	switch {
+	case err != nil:
+		item.err = err
+	case version == "":
+		item.current, item.changed = absent, true
+	default:
+		item.current = version
+	}
+	return item
+}
+
+// helmListing is a release as reported by `helm list --output json`.
+type helmListing struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Chart     string `json:"chart"`
+	Status    string `json:"status"`
+}
+
+func planHelmReleases(d dispatch.ClusterDispatcher) []planItem {
+	output, err := inspect(d, d.GetMasterNode(), "helm list --all-namespaces --all --output json")
+	return helmReleaseItems(output, err)
+}
+
+// helmReleaseItems compares the releases listed by helm list, or the error
+// listing them, with those a deployment installs.
+func helmReleaseItems(output string, err error) []planItem {
+	releases := append([]helmRelease{vaultRelease}, certManagerReleases...)
+	var listings []helmListing
+	if err == nil {
+		err = json.Unmarshal([]byte(output), &listings)
+	}
+	var items []planItem
+	for _, release := range releases {
+		resource := fmt.Sprintf("helm release %s/%s", release.namespace, release.name)
+		// Only cert-manager is installed at a fixed version.
+		var desiredVersion string
+		if release == certManagerReleases[0] {
+			desiredVersion = certManagerVersion
+		}
+		desired := strings.TrimSpace(desiredVersion + " deployed")
+		if err != nil && dispatch.ExitStatus(err) == 127 {
+			// Helm isn't installed, so neither is any release.
+			items = append(items, newPlanItem(resource, absent, desired))
+			continue
+		} else if err != nil {
+			items = append(items, planItem{resource: resource, err: err})
+			continue
+		}
+		item := newPlanItem(resource, absent, desired)
+		for _, listing := range listings {
+			if listing.Name != release.name || listing.Namespace != release.namespace {
+				continue
+			}
+			// Charts are listed with their version, e.g. cert-manager-v1.16.1.
+			version := strings.TrimPrefix(listing.Chart, release.name+"-")
+			item.current = fmt.Sprintf("%s %s", version, listing.Status)
+			item.changed = listing.Status != "deployed" ||
+				(desiredVersion != "" && version != desiredVersion)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// planResources compares the resources of a kind that a deployment creates in
+// a namespace, or cluster-wide if it's empty, with those that exist.
+func planResources(d dispatch.ClusterDispatcher, kind, namespace string, names ...string) []planItem {
+	cmd := fmt.Sprintf("kubectl get %s -o name", kind)
+	if namespace != "" {
+		cmd = fmt.Sprintf("kubectl get %s -n %s -o name", kind, namespace)
+	}
+	output, err := inspect(d, d.GetMasterNode(), cmd)
+	existing := strings.Fields(output)
+	var items []planItem
+	for _, name := range names {
+		resource := fmt.Sprintf("%s %s", kind, name)
+		if namespace != "" {
+			resource = fmt.Sprintf("%s %s/%s", kind, namespace, name)
+		}
+		if err != nil && dispatch.ExitStatus(err) == 127 {
+			// Without K3S, kubectl isn't installed, so neither is any resource.
+			items = append(items, newPlanItem(resource, absent, "present"))
+			continue
+		} else if err != nil {
+			items = append(items, planItem{resource: resource, err: err})
+			continue
+		}
+		current := absent
+		for _, e := range existing {
+			// Names are listed with their kind, e.g. namespace/vault.
+			if e == kind+"/"+name {
+				current = "present"
+			}
+		}
+		items = append(items, newPlanItem(resource, current, "present"))
+	}
+	return items
+}
+
+func planVault(d dispatch.ClusterDispatcher) planItem {
+	output, err := inspect(
+		d,
+		d.GetMasterNode(),
+		`kubectl exec -n vault vault-0 -- /bin/ash -c "VAULT_SKIP_VERIFY=1 vault status -format=json"`,
+	)
+	return vaultItem(output, err)
+}
+
+// vaultItem compares the status reported by vault status, or the error getting
+// it, with Vault's status after a deployment.
+func vaultItem(output string, err error) planItem {
+	const resource = "vault"
+	// vault status exits with 2 when Vault is sealed, but still reports its
+	// status.
+	var status struct {
+		Initialized bool `json:"initialized"`
+		Sealed      bool `json:"sealed"`
+	}
+	if jsonErr := json.Unmarshal([]byte(output), &status); jsonErr != nil {
+		if err == nil {
+			return planItem{resource: resource, err: jsonErr}
+		}
+		// Without a vault-0 pod, there is no Vault to inspect.
+		return newPlanItem(resource, absent, "initialized, unsealed")
+	}
+	current := "not initialized"
+	if status.Initialized && status.Sealed {
+		current = "initialized, sealed"
+	} else if status.Initialized {
+		current = "initialized, unsealed"
+	}
+	return newPlanItem(resource, current, "initialized, unsealed")
+}
+
+// printPlan prints the items of a plan as a diff, or as plan item events for
+// the json output format.
+func printPlan(items []planItem) error {
+	if dispatch.Output.Format == dispatch.OutputJSON {
+		for _, item := range items {
+			attrs := map[string]any{
+				"resource": item.resource,
+				"current":  item.current,
+				"desired":  item.desired,
+				"changed":  item.changed,
+			}
+			event := dispatch.Event{Type: dispatch.EventPlanItem, Attrs: attrs}
+			if item.err != nil {
+				event.Error = item.err.Error()
+			}
+			if err := dispatch.Output.Emit(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var create, change, unknown int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, item := range items {
+		var state string
+		switch item.action() {
+		case "?":
+			unknown++
+			state = fmt.Sprintf("could not inspect: %v", item.err)
+		case " ":
+			state = item.current
+		case "+":
+			create++
+			state = fmt.Sprintf("%s -> %s", item.current, item.desired)
+		case "~":
+			change++
+			state = fmt.Sprintf("%s -> %s", item.current, item.desired)
+		}
+		fmt.Fprintf(w, "%s %s\t%s\n", item.action(), item.resource, state)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	printInfo("Plan: %d to create, %d to change, %d not inspected.", create, change, unknown)
+	return nil
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kev-cao/log-console/utils/sliceutils"
+	"github.com/stretchr/testify/require"
+)
+
+// exitError is the error of a remote command that exited with a status.
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("process exited with status %d", int(e))
+}
+
+func (e exitError) ExitStatus() int {
+	return int(e)
+}
+
+// planLines formats plan items as their action, resource and current state.
+func planLines(items ...planItem) []string {
+	return sliceutils.Map(items, func(item planItem, _ int) string {
+		return fmt.Sprintf("%s %s: %s", item.action(), item.resource, item.current)
+	})
+}
+
+func TestHelmReleaseItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		err      error
+		expected []string
+	}{
+		{
+			name:   "nothing installed",
+			output: "[]",
+			expected: []string{
+				"+ helm release vault/vault: absent",
+				"+ helm release cert-manager/cert-manager: absent",
+				"+ helm release cert-manager/cert-manager-approver-policy: absent",
+				"+ helm release cert-manager/trust-manager: absent",
+			},
+		},
+		{
+			name: "deployed",
+			output: `[
+				{"name": "vault", "namespace": "vault", "chart": "vault-0.29.1", "status": "deployed"},
+				{"name": "cert-manager", "namespace": "cert-manager", "chart": "cert-manager-v1.16.1", "status": "deployed"},
+				{"name": "cert-manager-approver-policy", "namespace": "cert-manager", "chart": "cert-manager-approver-policy-v0.15.0", "status": "deployed"},
+				{"name": "trust-manager", "namespace": "cert-manager", "chart": "trust-manager-v0.12.0", "status": "failed"},
+				{"name": "vault", "namespace": "default", "chart": "vault-0.28.0", "status": "deployed"}
+			]`,
+			expected: []string{
+				"  helm release vault/vault: 0.29.1 deployed",
+				"  helm release cert-manager/cert-manager: v1.16.1 deployed",
+				"  helm release cert-manager/cert-manager-approver-policy: v0.15.0 deployed",
+				"~ helm release cert-manager/trust-manager: v0.12.0 failed",
+			},
+		},
+		{
+			name:   "other cert-manager version",
+			output: `[{"name": "cert-manager", "namespace": "cert-manager", "chart": "cert-manager-v1.15.0", "status": "deployed"}]`,
+			expected: []string{
+				"+ helm release vault/vault: absent",
+				"~ helm release cert-manager/cert-manager: v1.15.0 deployed",
+				"+ helm release cert-manager/cert-manager-approver-policy: absent",
+				"+ helm release cert-manager/trust-manager: absent",
+			},
+		},
+		{
+			name: "helm not installed",
+			err:  exitError(127),
+			expected: []string{
+				"+ helm release vault/vault: absent",
+				"+ helm release cert-manager/cert-manager: absent",
+				"+ helm release cert-manager/cert-manager-approver-policy: absent",
+				"+ helm release cert-manager/trust-manager: absent",
+			},
+		},
+		{
+			name: "node unreachable",
+			err:  errors.New("failed to connect to master"),
+			expected: []string{
+				"? helm release vault/vault: ",
+				"? helm release cert-manager/cert-manager: ",
+				"? helm release cert-manager/cert-manager-approver-policy: ",
+				"? helm release cert-manager/trust-manager: ",
+			},
+		},
+		{
+			name:   "unexpected output",
+			output: "Error: Kubernetes cluster unreachable",
+			expected: []string{
+				"? helm release vault/vault: ",
+				"? helm release cert-manager/cert-manager: ",
+				"? helm release cert-manager/cert-manager-approver-policy: ",
+				"? helm release cert-manager/trust-manager: ",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, planLines(helmReleaseItems(tc.output, tc.err)...))
+		})
+	}
+}
+
+func TestVaultItem(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		err      error
+		expected string
+	}{
+		{
+			name:     "unsealed",
+			output:   `{"initialized": true, "sealed": false}`,
+			expected: "  vault: initialized, unsealed",
+		},
+		{
+			name:     "sealed",
+			output:   `{"initialized": true, "sealed": true}`,
+			err:      exitError(2),
+			expected: "~ vault: initialized, sealed",
+		},
+		{
+			name:     "not initialized",
+			output:   `{"initialized": false, "sealed": true}`,
+			err:      exitError(2),
+			expected: "~ vault: not initialized",
+		},
+		{
+			name:     "absent",
+			output:   `Error from server (NotFound): pods "vault-0" not found`,
+			err:      exitError(1),
+			expected: "+ vault: absent",
+		},
+		{
+			name:     "unexpected output",
+			output:   "Vault v1.18.1",
+			expected: "? vault: ",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, []string{tc.expected}, planLines(vaultItem(tc.output, tc.err)))
+		})
+	}
+}
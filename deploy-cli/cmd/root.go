@@ -4,6 +4,9 @@ Copyright © 2024 Kevin Cao <kcao1998@gmail.com>
 package cmd
 
 import (
+	"fmt"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
 	"github.com/spf13/cobra"
 )
 
@@ -15,11 +18,77 @@ var rootCmd = &cobra.Command{
 	It provides a simple way to deploy the app to a variety of environments.`,
 }
 
+func init() {
+	rootCmd.PersistentFlags().VarP(
+		(*outputFormat)(&dispatch.Output.Format),
+		"output",
+		"o",
+		fmt.Sprintf(
+			"Output format. Options: %v. plain drops colors and live progress lines, "+
+				"json writes newline-delimited events.",
+			outputFormatOptions,
+		),
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&dispatch.Output.Timestamps,
+		"timestamps",
+		false,
+		"Prefix each line of node output with the time it was written.",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&dispatch.Output.Colors,
+		"color",
+		true,
+		"Give each node's output a distinct color when writing to a terminal.",
+	)
+	rootCmd.PersistentFlags().BoolVarP(
+		&globalLogFlags.Verbose,
+		"verbose",
+		"v",
+		false,
+		"Log debug messages, including every command run on a node.",
+	)
+	rootCmd.PersistentFlags().BoolVarP(
+		&globalLogFlags.Quiet,
+		"quiet",
+		"q",
+		false,
+		"Only log warnings and errors, and hide node output. Output is still written to the log file.",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&globalLogFlags.LogFile,
+		"log_file",
+		"",
+		"File to append every log message and line of node output to, regardless of --quiet.",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&globalDryRun,
+		"dry_run",
+		false,
+		"Print the commands, file transfers and project downloads that would happen on each node "+
+			"without carrying them out. Supported by deploy and teardown.",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&globalConfigFile,
+		"config",
+		"",
+		"YAML configuration file, e.g. with hooks to run before or after deploy and teardown steps. "+
+			"Defaults to deploy-cli/config.yaml in the user configuration directory, if it exists.",
+	)
+	rootCmd.MarkFlagsMutuallyExclusive("verbose", "quiet")
+	cobra.OnInitialize(setupLogging, loadConfig)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		cobra.CheckErr(err)
+		checkErr(err)
+	}
+	finishStep(nil)
+	finishTranscript(nil)
+	if logFile != nil {
+		logFile.Close()
 	}
 }
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/transcript"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var runsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "Browses the transcripts of past deploy and teardown runs.",
+	Long: `Browses the transcripts of past deploy and teardown runs. Each run records its flags,
+every command sent to each node with its exit status, timing and output, and its log, with
+secrets redacted. Transcripts are stored in $XDG_STATE_HOME/deploy-cli/runs, which defaults to
+~/.local/state/deploy-cli/runs.`,
+}
+
+var runsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists recorded runs, most recent first.",
+	Run: func(_ *cobra.Command, _ []string) {
+		runs, err := transcript.List()
+		if err != nil {
+			checkErr(err)
+		}
+		if len(runs) == 0 {
+			printInfo("No runs recorded.")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCOMMAND\tSTARTED\tDURATION\tSTATUS")
+		for _, run := range runs {
+			duration := "-"
+			if !run.Finished.IsZero() {
+				duration = run.Finished.Sub(run.Started).Round(time.Second).String()
+			}
+			fmt.Fprintf(
+				w, "%s\t%s\t%s\t%s\t%s\n",
+				run.ID, run.Command, run.Started.Format(time.DateTime), duration, run.Status(),
+			)
+		}
+		if err := w.Flush(); err != nil {
+			checkErr(err)
+		}
+	},
+}
+
+var runsShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Shows the flags, commands and output of a recorded run.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		run, commands, err := transcript.Load(args[0])
+		if err != nil {
+			checkErr(err)
+		}
+		logPath, err := transcript.LogPath(run.ID)
+		if err != nil {
+			checkErr(err)
+		}
+		printRun(run, commands, logPath)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runsCmd)
+	runsCmd.AddCommand(runsListCmd)
+	runsCmd.AddCommand(runsShowCmd)
+}
+
+// printRun prints a run's flags followed by every command sent during it.
+func printRun(run transcript.Run, commands []transcript.Command, logPath string) {
+	fmt.Printf("Run:     %s\n", run.ID)
+	fmt.Printf("Command: %s\n", strings.Join(append([]string{run.Command}, run.Args...), " "))
+	fmt.Printf("Started: %s\n", run.Started.Format(time.DateTime))
+	fmt.Printf("Status:  %s\n", run.Status())
+	if run.Error != "" {
+		fmt.Printf("Error:   %s\n", run.Error)
+	}
+	fmt.Printf("Log:     %s\n", logPath)
+	fmt.Println(header("Flags"))
+	names := make([]string, 0, len(run.Flags))
+	for name := range run.Flags {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("--%s=%s\n", name, run.Flags[name])
+	}
+	for i, c := range commands {
+		fmt.Println(header(fmt.Sprintf(
+			"Command %d on %s (exit status %d, %s)",
+			i+1, c.Node, c.ExitStatus, c.Duration.Round(time.Millisecond),
+		)))
+		fmt.Println(c.Command)
+		if len(c.Env) > 0 {
+			fmt.Printf("Env: %s\n", strings.Join(c.Env, ", "))
+		}
+		if c.Error != "" {
+			fmt.Printf("Error: %s\n", c.Error)
+		}
+		printOutput("stdout", c.Stdout)
+		printOutput("stderr", c.Stderr)
+	}
+}
+
+func printOutput(stream, output string) {
+	if output == "" {
+		return
+	}
+	fmt.Printf("--- %s ---\n", stream)
+	fmt.Println(strings.TrimSuffix(output, "\n"))
+}
+
+// startTranscript starts recording the run of a command with its resolved
+// flags. A run that can't be recorded is still carried out.
+func startTranscript(cmd *cobra.Command, args []string) {
+	flags := make(map[string]string)
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		flags[flag.Name] = flag.Value.String()
+	})
+	recorder, err := transcript.Start(cmd.CommandPath(), args, flags)
+	if err != nil {
+		slog.Warn("Could not record transcript of run", "err", err)
+		return
+	}
+	dispatch.Transcript = recorder
+	setLogger()
+	slog.Debug("recording transcript", "run", recorder.ID())
+}
+
+// finishTranscript finishes recording the run, if it's being recorded.
+func finishTranscript(err error) {
+	if dispatch.Transcript == nil {
+		return
+	}
+	if finishErr := dispatch.Transcript.Finish(err); finishErr != nil {
+		slog.Warn("Could not finish transcript of run", "err", finishErr)
+	}
+	dispatch.Transcript = nil
+	setLogger()
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fatih/structs"
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/spf13/cobra"
+)
+
+var scaleCmd = &cobra.Command{
+	Use:   "scale",
+	Short: "Scales the cluster up or down to a number of worker nodes.",
+	Long: `Scales the cluster up or down to a number of worker nodes. When scaling up, new
+worker nodes are launched and joined to the K3S cluster. When scaling down, the last worker
+nodes are cordoned, drained and deleted from the K3S cluster before they are destroyed.
+Only supported for multipass deployments.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectDryRun(cmd, args)
+		if err := cmd.ValidateRequiredFlags(); err != nil {
+			checkErr(err)
+		}
+		if err := globalScaleFlags.validate(); err != nil {
+			checkErr(err)
+		}
+		dispatcher, err := dispatchers.GetDispatcher(
+			structs.Map(globalScaleFlags),
+			dispatchMethod(globalScaleFlags.Method),
+		)
+		if err != nil {
+			checkErr(err)
+		}
+		defer dispatcher.Cleanup()
+		s, ok := dispatcher.(scaler)
+		if !ok {
+			checkErr(fmt.Errorf("Scaling is not supported for %s deployments.", globalScaleFlags.Method))
+		}
+		if err := scaleWorkers(s, globalScaleFlags.Workers); err != nil {
+			checkErr(err)
+		}
+	},
+}
+
+// scaler is a dispatcher that can add and remove worker nodes.
+type scaler interface {
+	dispatch.ClusterDispatcher
+	AddWorkers(n int) ([]dispatch.Node, error)
+	RemoveWorkers(n int) ([]dispatch.Node, error)
+}
+
+var globalScaleFlags = scaleFlags{
+	Method:        MULTIPASS,
+	LaunchOptions: newLaunchFlags(),
+}
+
+func init() {
+	rootCmd.AddCommand(scaleCmd)
+	scaleCmd.Flags().VarP(
+		&globalScaleFlags.Method,
+		"method",
+		"m",
+		fmt.Sprintf("Deployment method. Options: %v", dispatchMethodOptions),
+	)
+	addClusterNameFlag(scaleCmd.Flags(), &globalScaleFlags.ClusterName)
+	scaleCmd.Flags().IntVarP(
+		&globalScaleFlags.NumNodes,
+		"nodes",
+		"n",
+		3,
+		"Current number of nodes in the cluster",
+	)
+	scaleCmd.Flags().IntVarP(
+		&globalScaleFlags.Workers,
+		"workers",
+		"w",
+		0,
+		"Number of worker nodes to scale the cluster to",
+	)
+	addLaunchFlags(scaleCmd.Flags(), &globalScaleFlags.LaunchOptions)
+
+	scaleCmd.MarkFlagRequired("workers")
+}
+
+type scaleFlags struct {
+	Method        dispatchMethod
+	ClusterName   clusterName
+	NumNodes      int
+	Workers       int
+	LaunchOptions launchFlags `structs:",omitnested"`
+}
+
+func (f *scaleFlags) validate() error {
+	if f.Method != MULTIPASS {
+		return errors.New("Scaling is only supported for multipass deployments.")
+	}
+	if f.NumNodes <= 0 {
+		return errors.New("Number of nodes must be greater than 0.")
+	}
+	if f.Workers < 0 {
+		return errors.New("Number of workers cannot be negative.")
+	}
+	return nil
+}
+
+// scaleWorkers adds or removes worker nodes until the cluster has the given
+// number of workers.
+func scaleWorkers(s scaler, workers int) error {
+	current := len(s.GetWorkerNodes())
+	switch {
+	case workers > current:
+		startStep(fmt.Sprintf("Adding %d worker nodes...", workers-current))
+		nodes, err := s.AddWorkers(workers - current)
+		if err != nil {
+			return err
+		}
+		startStep("Joining worker nodes to K3S...")
+		if err := joinWorkers(s, nodes); err != nil {
+			return err
+		}
+		if err := waitK3S(s); err != nil {
+			return err
+		}
+	case workers < current:
+		removed := s.GetWorkerNodes()[workers:]
+		startStep(fmt.Sprintf("Removing %d worker nodes...", len(removed)))
+		if err := drainWorkers(s, removed); err != nil {
+			return err
+		}
+		if _, err := s.RemoveWorkers(len(removed)); err != nil {
+			return err
+		}
+	default:
+		printInfo("Cluster already has %d workers.", workers)
+		return nil
+	}
+	printInfo("Cluster scaled to %d workers.", workers)
+	return nil
+}
+
+// drainWorkers cordons and drains the worker nodes and deletes them from the
+// K3S cluster so that their workloads are rescheduled before they are destroyed.
+func drainWorkers(d dispatch.ClusterDispatcher, workers []dispatch.Node) error {
+	master := d.GetMasterNode()
+	for _, node := range workers {
+		if err := d.SendCommands(
+			master,
+			dispatch.NewCommands(
+				[]string{
+					"kubectl cordon " + node.Kubename,
+					"kubectl drain " + node.Kubename +
+						" --ignore-daemonsets --delete-emptydir-data --timeout=120s",
+					"kubectl delete node " + node.Kubename,
+				},
+				dispatch.WithEnv(kubeEnv),
+				dispatch.WithTimeout(3*time.Minute),
+				dispatch.WithOsPipe(),
+				dispatch.WithPrefixWriter(master),
+			)...,
+		); err != nil {
+			return fmt.Errorf("error removing %s from K3S: %w", node.Kubename, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/stretchr/testify/require"
+)
+
+// localScaler is a local cluster that can add and remove worker nodes.
+type localScaler struct {
+	*localCluster
+}
+
+func (s localScaler) AddWorkers(n int) ([]dispatch.Node, error) {
+	for range n {
+		name := fmt.Sprintf("worker-%d", len(s.nodes))
+		s.nodes = append(s.nodes, dispatch.Node{Name: name, Kubename: name})
+	}
+	return s.nodes[len(s.nodes)-n:], nil
+}
+
+func (s localScaler) RemoveWorkers(n int) ([]dispatch.Node, error) {
+	removed := s.nodes[len(s.nodes)-n:]
+	s.nodes = s.nodes[:len(s.nodes)-n]
+	return removed, nil
+}
+
+func TestScaleWorkers(t *testing.T) {
+	c := newLocalCluster(t, "master", "worker-1")
+	s := localScaler{c}
+
+	require.NoError(t, scaleWorkers(s, 3))
+	require.Len(t, s.GetWorkerNodes(), 3)
+	for _, worker := range []string{"worker-2", "worker-3"} {
+		require.Equal(t, []string{
+			fmt.Sprintf(`curl -sfL https://get.k3s.io | K3S_NODE_NAME="%s" K3S_URL="https://master:6443" K3S_TOKEN="K10token" sh -`, worker),
+		}, c.lines[worker])
+	}
+	// The scaled cluster is waited for once the new workers have joined.
+	executed := ran(t, c.bin)
+	require.Equal(t, []string{
+		"kubectl get nodes",
+		"kubectl wait --for=condition=Ready node --all --timeout=300s",
+	}, executed[len(executed)-2:])
+
+	require.NoError(t, scaleWorkers(s, 1))
+	require.Len(t, s.GetWorkerNodes(), 1)
+	executed = ran(t, c.bin)
+	var drained []string
+	for _, worker := range []string{"worker-2", "worker-3"} {
+		drained = append(drained,
+			"kubectl cordon "+worker,
+			"kubectl drain "+worker+" --ignore-daemonsets --delete-emptydir-data --timeout=120s",
+			"kubectl delete node "+worker,
+		)
+	}
+	require.Equal(t, drained, executed[len(executed)-6:])
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/structs"
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Reports the health of each node in the cluster.",
+	Long: `Reports the health of each node in the cluster, including whether it is reachable,
+its latency, its state (VM state for multipass, authentication result for SSH) and its uptime.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		rejectDryRun(cmd, args)
+		if err := cmd.ValidateRequiredFlags(); err != nil {
+			checkErr(err)
+		}
+		if err := globalStatusFlags.validate(); err != nil {
+			checkErr(err)
+		}
+		dispatcher, err := dispatchers.GetDispatcher(
+			structs.Map(globalStatusFlags),
+			dispatchMethod(globalStatusFlags.Method),
+		)
+		if err != nil {
+			checkErr(err)
+		}
+		defer dispatcher.Cleanup()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		statuses := dispatcher.Status(ctx)
+		if err := printStatus(statuses); err != nil {
+			checkErr(err)
+		}
+		for _, status := range statuses {
+			if !status.Healthy() {
+				checkErr(errors.New("One or more nodes are unhealthy."))
+			}
+		}
+	},
+}
+
+// Unreachable nodes are always allowed so that they show up in the report.
+var globalStatusFlags = statusFlags{AllowUnreachable: true}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().VarP(
+		&globalStatusFlags.Method,
+		"method",
+		"m",
+		fmt.Sprintf("Deployment method. Options: %v", dispatchMethodOptions),
+	)
+	addClusterNameFlag(statusCmd.Flags(), &globalStatusFlags.ClusterName)
+	statusCmd.Flags().IntVarP(
+		&globalStatusFlags.NumNodes,
+		"nodes",
+		"n",
+		3,
+		"Number of nodes in the cluster",
+	)
+	addRemoteFlags(
+		statusCmd.Flags(),
+		&globalStatusFlags.Remotes,
+		&globalStatusFlags.IdentityFile,
+		&globalStatusFlags.JumpHosts,
+	)
+
+	statusCmd.MarkFlagRequired("method")
+}
+
+type statusFlags struct {
+	Method           dispatchMethod
+	ClusterName      clusterName
+	NumNodes         int
+	Remotes          []string
+	IdentityFile     string
+	JumpHosts        []string
+	AllowUnreachable bool
+}
+
+func (f *statusFlags) validate() error {
+	if f.NumNodes <= 0 {
+		return errors.New("Number of nodes must be greater than 0.")
+	}
+
+	if f.Method == SSH {
+		if len(f.Remotes) == 0 {
+			return errors.New("Remote addresses must be provided for SSH deployments.")
+		} else if len(f.Remotes) != f.NumNodes {
+			return errors.New("Number of remotes must match number of nodes.")
+		}
+	}
+	return nil
+}
+
+// printStatus prints the node statuses as a table, or as node status events for
+// the json output format.
+func printStatus(statuses []dispatch.NodeStatus) error {
+	if dispatch.Output.Format == dispatch.OutputJSON {
+		for _, status := range statuses {
+			if err := dispatch.Output.Emit(dispatch.Event{
+				Type:   dispatch.EventNodeStatus,
+				Node:   status.Node.Name,
+				Status: &status,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tKUBENAME\tSTATE\tREACHABLE\tLATENCY\tUPTIME\tERROR")
+	for _, status := range statuses {
+		latency, uptime, errMsg := "-", "-", "-"
+		if status.Reachable {
+			latency = status.Latency.Round(time.Millisecond).String()
+			uptime = status.Uptime.String()
+		}
+		if status.Err != nil {
+			errMsg = status.Err.Error()
+		}
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%t\t%s\t%s\t%s\n",
+			status.Node.Name, status.Node.Kubename, status.State, status.Reachable, latency, uptime, errMsg,
+		)
+	}
+	return w.Flush()
+}
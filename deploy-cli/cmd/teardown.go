@@ -6,6 +6,7 @@ import (
 
 	"github.com/fatih/structs"
 	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/pipeline"
 	"github.com/spf13/cobra"
 )
 
@@ -14,13 +15,14 @@ var teardownCmd = &cobra.Command{
 	Short: "Provides functionality for tearing down a cluster.",
 	Long: `Provides functionality for tearing down a cluster.
 	It resets the cluster to its initial state before deployment.`,
-	PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		if err := cmd.ValidateRequiredFlags(); err != nil {
-			cobra.CheckErr(err)
+			checkErr(err)
 		}
 		if err := globalTearDownFlags.validate(); err != nil {
-			cobra.CheckErr(err)
+			checkErr(err)
 		}
+		startTranscript(cmd, args)
 	},
 	Run: func(_ *cobra.Command, _ []string) {
 		dispatcher, err := dispatchers.GetDispatcher(
@@ -28,21 +30,13 @@ var teardownCmd = &cobra.Command{
 			dispatchMethod(globalTearDownFlags.Method),
 		)
 		if err != nil {
-			cobra.CheckErr(err)
+			checkErr(err)
 		}
 		defer dispatcher.Cleanup()
-		fmt.Println(header("Tearing down everything..."))
-		customTeardown, ok := dispatcher.(interface{ Teardown() error })
-		if ok {
-			if err := customTeardown.Teardown(); err != nil {
-				cobra.CheckErr(err)
-			}
-		} else {
-			if err := teardownAll(dispatcher); err != nil {
-				cobra.CheckErr(err)
-			}
+		if err := runTeardown(dispatcher, teardownStep(dispatcher)); err != nil {
+			checkErr(err)
 		}
-		fmt.Println("Tear down successful.")
+		printInfo("Tear down successful.")
 		return
 	},
 }
@@ -57,6 +51,7 @@ func init() {
 		"m",
 		fmt.Sprintf("Deployment method. Options: %v", dispatchMethodOptions),
 	)
+	addClusterNameFlag(teardownCmd.PersistentFlags(), &globalTearDownFlags.ClusterName)
 	teardownCmd.PersistentFlags().IntVarP(
 		&globalTearDownFlags.NumNodes,
 		"nodes",
@@ -64,19 +59,16 @@ func init() {
 		3,
 		"Number of nodes to teardown",
 	)
-	teardownCmd.PersistentFlags().StringSliceVarP(
+	addRemoteFlags(
+		teardownCmd.PersistentFlags(),
 		&globalTearDownFlags.Remotes,
-		"remotes",
-		"r",
-		nil,
-		"User-qualified hostnames for each remote node (required for SSH deployments). First address is the master node.",
-	)
-	teardownCmd.PersistentFlags().StringVarP(
 		&globalTearDownFlags.IdentityFile,
-		"identity_file",
-		"i",
-		"~/.ssh/id_rsa",
-		"The identity (private key) file to use for SSH deployments.",
+		&globalTearDownFlags.JumpHosts,
+	)
+	addAllowUnreachableFlag(
+		teardownCmd.PersistentFlags(),
+		&globalTearDownFlags.AllowUnreachable,
+		"Commands sent to unreachable nodes will fail.",
 	)
 
 	teardownCmd.MarkPersistentFlagRequired("method")
@@ -84,10 +76,13 @@ func init() {
 }
 
 type teardownFlags struct {
-	Method       dispatchMethod
-	NumNodes     int
-	Remotes      []string
-	IdentityFile string
+	Method           dispatchMethod
+	ClusterName      clusterName
+	NumNodes         int
+	Remotes          []string
+	IdentityFile     string
+	JumpHosts        []string
+	AllowUnreachable bool
 }
 
 func (f *teardownFlags) validate() error {
@@ -100,13 +95,57 @@ func (f *teardownFlags) validate() error {
 			return errors.New("Remote addresses must be provided for SSH deployments.")
 		} else if len(f.Remotes) != f.NumNodes {
 			return errors.New("Number of remotes must match number of nodes.")
-		} else if f.IdentityFile == "" {
-			return errors.New("Private key file must be provided for SSH deployments.")
 		}
 	}
 	return nil
 }
 
+// runTeardown runs teardown steps, with the hooks configured for them.
+func runTeardown(d dispatch.ClusterDispatcher, steps ...pipeline.Step) error {
+	p, err := pipeline.New(steps...)
+	if err != nil {
+		return err
+	}
+	p.Start = func(step pipeline.Step) {
+		startStep(step.Title)
+	}
+	addHooks(p, d)
+	return p.Run(pipeline.Selection{})
+}
+
+func teardownStep(d dispatch.ClusterDispatcher) pipeline.Step {
+	return pipeline.Step{
+		Name:  "teardown",
+		Title: "Tearing down everything...",
+		Run: func() error {
+			customTeardown, ok := d.(interface{ Teardown() error })
+			var err error
+			if ok {
+				err = customTeardown.Teardown()
+			}
+			// Dry runs only support a custom teardown if the wrapped dispatcher does.
+			if !ok || errors.Is(err, errors.ErrUnsupported) {
+				return forgetTornDown(d, "cert_manager", teardownAll(d))
+			} else if err != nil {
+				// Some of the nodes may have been deleted.
+				return forgetTornDown(d, "launch", err)
+			}
+			return forgetTornDown(d, "", nil)
+		},
+	}
+}
+
+// forgetTornDown forgets the deployment steps undone by a teardown, from the
+// given step on, and returns the teardown's error. Without a step, the whole
+// deployment state is forgotten.
+func forgetTornDown(d dispatch.ClusterDispatcher, from string, err error) error {
+	key := clusterKey(globalTearDownFlags.Method, globalTearDownFlags.ClusterName, globalTearDownFlags.Remotes)
+	if forgetErr := forgetSteps(d, key, from); forgetErr != nil {
+		return errors.Join(err, fmt.Errorf("error updating the deployment state: %w", forgetErr))
+	}
+	return err
+}
+
 func teardownAll(d dispatch.ClusterDispatcher) error {
 	if err := teardownVault(d); err != nil {
 		return err
@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/fatih/structs"
 	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/pipeline"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
 )
@@ -18,14 +20,13 @@ var teardownK3sCmd = &cobra.Command{
 			dispatchMethod(globalTearDownFlags.Method),
 		)
 		if err != nil {
-			cobra.CheckErr(err)
+			checkErr(err)
 		}
 		defer dispatcher.Cleanup()
-		fmt.Println(header("Tearing down K3S..."))
-		if err := teardownK3s(dispatcher); err != nil {
-			cobra.CheckErr(err)
+		if err := runTeardown(dispatcher, teardownK3sStep(dispatcher)); err != nil {
+			checkErr(err)
 		}
-		fmt.Println("Tear down successful.")
+		printInfo("Tear down successful.")
 		return
 	},
 	TraverseChildren: true,
@@ -35,6 +36,14 @@ func init() {
 	teardownCmd.AddCommand(teardownK3sCmd)
 }
 
+func teardownK3sStep(d dispatch.ClusterDispatcher) pipeline.Step {
+	return pipeline.Step{
+		Name:  "teardown_k3s",
+		Title: "Tearing down K3S...",
+		Run:   func() error { return forgetTornDown(d, "k3s", teardownK3s(d)) },
+	}
+}
+
 func teardownK3s(d dispatch.ClusterDispatcher) error {
 	master := d.GetMasterNode()
 	if err := d.SendCommands(
@@ -49,16 +58,22 @@ func teardownK3s(d dispatch.ClusterDispatcher) error {
 	}
 
 	var wg errgroup.Group
-	for _, node := range d.GetWorkerNodes() {
+	workers := d.GetWorkerNodes()
+	progress := dispatch.NewProgressRenderer(os.Stdout, workers)
+	for _, node := range workers {
 		wg.Go(func() error {
-			return d.SendCommands(
+			if err := d.SendCommands(
 				node,
 				dispatch.NewCommand(
 					"/usr/local/bin/k3s-agent-uninstall.sh",
-					dispatch.WithOsPipe(),
-					dispatch.WithPrefixWriter(node),
+					dispatch.WithProgress(progress, node),
 				),
-			)
+			); err != nil {
+				progress.SetStatus(node, fmt.Sprintf("Error uninstalling K3S agent: %v", err))
+				return err
+			}
+			progress.SetStatus(node, "K3S agent uninstalled.")
+			return nil
 		})
 	}
 	if err := wg.Wait(); err != nil {
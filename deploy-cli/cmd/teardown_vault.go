@@ -2,9 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"log/slog"
 
 	"github.com/fatih/structs"
 	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/kev-cao/log-console/deploy-cli/pipeline"
+	"github.com/kev-cao/log-console/utils/sliceutils"
 	"github.com/spf13/cobra"
 )
 
@@ -17,14 +20,13 @@ var teardownVaultCmd = &cobra.Command{
 			dispatchMethod(globalTearDownFlags.Method),
 		)
 		if err != nil {
-			cobra.CheckErr(err)
+			checkErr(err)
 		}
 		defer dispatcher.Cleanup()
-		fmt.Println(header("Tearing down Vault..."))
-		if err := teardownVault(dispatcher); err != nil {
-			cobra.CheckErr(err)
+		if err := runTeardown(dispatcher, teardownVaultStep(dispatcher)); err != nil {
+			checkErr(err)
 		}
-		fmt.Println("Tear down successful.")
+		printInfo("Tear down successful.")
 		return
 	},
 	TraverseChildren: true,
@@ -34,30 +36,74 @@ func init() {
 	teardownCmd.AddCommand(teardownVaultCmd)
 }
 
+func teardownVaultStep(d dispatch.ClusterDispatcher) pipeline.Step {
+	return pipeline.Step{
+		Name:  "teardown_vault",
+		Title: "Tearing down Vault...",
+		Run:   func() error { return forgetTornDown(d, "cert_manager", teardownVault(d)) },
+	}
+}
+
+// helmRelease is a Helm release installed by a deployment.
+type helmRelease struct {
+	name      string
+	namespace string
+}
+
+var (
+	vaultRelease        = helmRelease{"vault", "vault"}
+	certManagerReleases = []helmRelease{
+		{"cert-manager", "cert-manager"},
+		{"cert-manager-approver-policy", "cert-manager"},
+		{"trust-manager", "cert-manager"},
+	}
+)
+
 func teardownVault(d dispatch.ClusterDispatcher) error {
+	if err := uninstallReleases(d, append([]helmRelease{vaultRelease}, certManagerReleases...)...); err != nil {
+		return fmt.Errorf("error deleting vault resources: %w", err)
+	}
+	if err := sendKubeCommands(
+		d,
+		"kubectl delete -l app=vault --all-namespaces "+
+			"$(kubectl api-resources --verbs=delete -o name | tr \"\\n\" \",\" | sed -e 's/,$//')",
+	); err != nil {
+		return fmt.Errorf("error deleting vault resources: %w", err)
+	}
+	return removeVaultStorage(d)
+}
+
+// uninstallReleases uninstalls Helm releases, if they're installed.
+func uninstallReleases(d dispatch.ClusterDispatcher, releases ...helmRelease) error {
+	return sendKubeCommands(d, sliceutils.Map(releases, func(r helmRelease, _ int) string {
+		return fmt.Sprintf("helm uninstall %s -n %s --ignore-not-found", r.name, r.namespace)
+	})...)
+}
+
+// sendKubeCommands sends commands that manage the cluster to the master node.
+func sendKubeCommands(d dispatch.ClusterDispatcher, cmds ...string) error {
 	master := d.GetMasterNode()
-	if err := d.SendCommands(
+	return d.SendCommands(
 		master,
 		dispatch.NewCommands(
-			[]string{
-				"helm uninstall vault -n vault --ignore-not-found",
-				"helm uninstall cert-manager -n cert-manager --ignore-not-found",
-				"helm uninstall cert-manager-approver-policy -n cert-manager --ignore-not-found",
-				"helm uninstall trust-manager -n cert-manager --ignore-not-found",
-				"kubectl delete -l app=vault --all-namespaces " +
-					"$(kubectl api-resources --verbs=delete -o name | tr \"\\n\" \",\" | sed -e 's/,$//')",
-			},
-			dispatch.WithEnv(map[string]string{
-				"KUBECONFIG": "/etc/rancher/k3s/k3s.yaml",
-			}),
+			cmds,
+			dispatch.WithEnv(kubeEnv),
 			dispatch.WithOsPipe(),
 			dispatch.WithPrefixWriter(master),
 		)...,
-	); err != nil {
-		return fmt.Errorf("error deleting vault resources: %w", err)
-	}
+	)
+}
 
+// removeVaultStorage removes the storage of the vault pods from every node.
+// Nodes that couldn't be connected to are skipped, and their storage is left
+// behind.
+func removeVaultStorage(d dispatch.ClusterDispatcher) error {
+	reachability, _ := d.(interface{ Reachable(dispatch.Node) bool })
 	for _, node := range d.GetNodes() {
+		if reachability != nil && !reachability.Reachable(node) {
+			slog.Warn("Skipping vault storage cleanup on unreachable node", "node", node.Name)
+			continue
+		}
 		if err := d.SendCommands(
 			node,
 			dispatch.NewCommand(
@@ -69,6 +115,5 @@ func teardownVault(d dispatch.ClusterDispatcher) error {
 			return fmt.Errorf("error cleaning up vault storage on %s: %w", node.Name, err)
 		}
 	}
-
 	return nil
 }
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/stretchr/testify/require"
+)
+
+// partialCluster is a local cluster where some nodes couldn't be connected to.
+type partialCluster struct {
+	*localCluster
+	unreachable string
+}
+
+func (c *partialCluster) Reachable(node dispatch.Node) bool {
+	return node.Name != c.unreachable
+}
+
+func (c *partialCluster) SendCommands(node dispatch.Node, cmds ...dispatch.Command) error {
+	if node.Name == c.unreachable {
+		return errors.New("failed to connect to worker-1")
+	}
+	return c.localCluster.SendCommands(node, cmds...)
+}
+
+func TestRemoveVaultStorage(t *testing.T) {
+	c := &partialCluster{newLocalCluster(t, "master", "worker-1", "worker-2"), "worker-1"}
+	require.NoError(t, removeVaultStorage(c))
+	require.Equal(t, []string{
+		"sudo rm -rf /srv/cluster/storage/vault",
+		"sudo rm -rf /srv/cluster/storage/vault",
+	}, ran(t, c.bin))
+	require.NotContains(t, c.lines, "worker-1")
+}
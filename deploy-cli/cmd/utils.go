@@ -5,6 +5,7 @@ import (
 	"io"
 	"strings"
 
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
 	"github.com/kev-cao/log-console/utils/structures"
 )
 
@@ -16,6 +17,9 @@ func header(s string) string {
 		dividerN = len(s) + padding
 	}
 	divider := strings.Repeat("-", dividerN)
+	if dispatch.Output.Format != dispatch.OutputText {
+		return fmt.Sprintf("%s\n%s\n%s", divider, s, divider)
+	}
 	return fmt.Sprintf("\x1b[32;1m%s\n%s\n%s\x1b[0m", divider, s, divider)
 }
 
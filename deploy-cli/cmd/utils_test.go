@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"bytes"
+	"io/fs"
 	"regexp"
 	"strings"
 	"testing"
 
+	"github.com/kev-cao/log-console/k8s"
 	"github.com/stretchr/testify/require"
 )
 
@@ -84,3 +86,24 @@ func TestCapturingPipe(t *testing.T) {
 		})
 	}
 }
+
+func TestManifests(t *testing.T) {
+	for _, name := range []string{
+		vaultManifest,
+		vaultOverrides,
+		certificatesManifest,
+		trustBundleManifest,
+		certWatcherManifest,
+		certWatcherScript,
+	} {
+		// A line of EOF would end the heredoc the manifest is streamed in.
+		require.NotRegexp(t, `(?m)^EOF$`, manifest(name), name)
+		cmd := withManifest("kubectl apply -f - <<'EOF'", name)
+		require.True(t, strings.HasPrefix(cmd, "kubectl apply -f - <<'EOF'\n"), name)
+		require.True(t, strings.HasSuffix(cmd, "\nEOF"), name)
+		require.Contains(t, cmd, manifest(name), name)
+	}
+	// Only the manifests deploy-cli applies are embedded.
+	_, err := fs.Stat(k8s.Manifests, "cockroach")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -6,9 +6,11 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/kev-cao/log-console/utils/sliceutils"
+	"github.com/kev-cao/log-console/utils/stringutils"
 )
 
 type Command struct {
@@ -39,6 +41,14 @@ func (c *Command) Timeout() time.Duration {
 	return c.timeout
 }
 
+// Line returns the command line that runs the command on a node, with its
+// environment bound in front of it. The bindings only apply to the first
+// command of the line, so commands that need them throughout, e.g. loops, are
+// run by a shell, as in "bash -c 'until ...'".
+func (c *Command) Line() string {
+	return strings.TrimSpace(stringutils.BuildEnvBindings(c.env) + " " + c.cmd)
+}
+
 type optionLoader func(Command) Command
 
 // NewCommand creates a command object from a command string with provided options.
@@ -97,20 +107,32 @@ func WithStderr(w io.Writer) optionLoader {
 	}
 }
 
-// WithPrefixWriter sets the prefix writer for the command. Must be used after
+// WithPrefixWriter writes the command's output through the shared Output
+// multiplexer, prefixing each line with the node's name. Must be used after
 // WithStdout or WithStderr.
 func WithPrefixWriter(node Node) optionLoader {
 	return func(c Command) Command {
 		if c.stdout != nil {
-			c.stdout = NewPrefixWriter(node.Name, c.stdout)
+			c.stdout = Output.Writer(node.Name, c.stdout)
 		}
 		if c.stderr != nil {
-			c.stderr = NewPrefixWriter(node.Name, c.stderr)
+			c.stderr = Output.Writer(node.Name, c.stderr)
 		}
 		return c
 	}
 }
 
+// WithProgress sets the stdout and stderr of the command to the node's status
+// line of a progress renderer.
+func WithProgress(r *ProgressRenderer, node Node) optionLoader {
+	return func(c Command) Command {
+		w := r.Writer(node)
+		c.stdout = w
+		c.stderr = w
+		return c
+	}
+}
+
 func WithEnv(env map[string]string) optionLoader {
 	return func(c Command) Command {
 		for k, v := range env {
@@ -120,23 +142,38 @@ func WithEnv(env map[string]string) optionLoader {
 	}
 }
 
+// UserQualifiedHostname is a remote host with an optional user and port. The
+// host may be an FQDN, an IP address, or an alias resolved through the SSH
+// config.
 type UserQualifiedHostname struct {
 	User string
 	FQDN string
+	// Port is the SSH port, if given explicitly.
+	Port string
 }
 
 func (r UserQualifiedHostname) String() string {
-	return fmt.Sprintf("%s@%s", r.User, r.FQDN)
+	s := r.FQDN
+	if r.User != "" {
+		s = fmt.Sprintf("%s@%s", r.User, s)
+	}
+	if r.Port != "" {
+		s = fmt.Sprintf("%s:%s", s, r.Port)
+	}
+	return s
 }
 
 func (r *UserQualifiedHostname) ParseString(s string) (*UserQualifiedHostname, error) {
-	uqhnPattern := regexp.MustCompile(`(^[a-zA-Z0-9](?:[a-zA-Z0-9._%-]*[a-zA-Z0-9])?)@([a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*\.[a-zA-Z]{2,63}$)`)
+	uqhnPattern := regexp.MustCompile(
+		`^(?:([a-zA-Z0-9](?:[a-zA-Z0-9._%-]*[a-zA-Z0-9])?)@)?([a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*)(?::([0-9]{1,5}))?$`,
+	)
 	matches := uqhnPattern.FindStringSubmatch(s)
 	if matches == nil {
 		return nil, fmt.Errorf("invalid user qualified hostname: %s", s)
 	}
 	r.User = matches[1]
 	r.FQDN = matches[2]
+	r.Port = matches[3]
 	return r, nil
 }
 
@@ -147,6 +184,20 @@ type Node struct {
 	// `worker-<n>` for worker nodes (1-indexed).
 	Kubename string
 	Remote   UserQualifiedHostname
+	// Hostname is the address the remote resolves to, e.g. the HostName of an
+	// SSH config alias, if it differs from the remote's.
+	Hostname string
+}
+
+// Address returns the address the cluster and the user reach the node at.
+func (n Node) Address() string {
+	if n.Hostname != "" {
+		return n.Hostname
+	}
+	if n.Remote.FQDN != "" {
+		return n.Remote.FQDN
+	}
+	return n.Name
 }
 
 type ClusterDispatcher interface {
@@ -158,6 +209,8 @@ type ClusterDispatcher interface {
 	GetWorkerNodes() []Node
 	// Ready checks if the cluster is ready to accept commands.
 	Ready() bool
+	// Status returns a health report for each node in the cluster.
+	Status(ctx context.Context) []NodeStatus
 	// SendCommands sends commands to a node in the cluster.
 	SendCommands(node Node, cmds ...Command) error
 	// SendCommandsContext sends commands to a node in the cluster with a custom context.
@@ -171,50 +224,3 @@ type ClusterDispatcher interface {
 	// Cleanup disposes of any held resources.
 	Cleanup() error
 }
-
-type PrefixWriter struct {
-	prefix string
-	writer io.Writer
-	// Both hasWritten and lastWroteNewline are used to determine if the prefix
-	// needs to be written. If hasWritten is false, the prefix is written. If
-	// lastWroteNewline is true, then on the next write, the prefix will be written.
-	// lastWroteNewline means that the lsat time Write was called, a newline was written
-	// as the last character.
-	hasWritten       bool
-	lastWroteNewline bool
-}
-
-func NewPrefixWriter(prefix string, writer io.Writer) io.Writer {
-	if writer == nil {
-		return nil
-	}
-	return &PrefixWriter{prefix: prefix, writer: writer}
-}
-
-// Write writes the prefix before the actual bytes to the underlying writer.
-func (p *PrefixWriter) Write(b []byte) (n int, err error) {
-	if p.writer == nil {
-		return len(b), nil
-	}
-	s := string(b)
-	toWrite := make([]byte, 0, len(b)+len(p.prefix)+3)
-	if !p.hasWritten || p.lastWroteNewline {
-		toWrite = append(toWrite, []byte("["+p.prefix+"] ")...)
-		p.hasWritten = true
-	}
-	p.lastWroteNewline = false
-	for i, c := range s {
-		toWrite = append(toWrite, byte(c))
-		if c == '\n' {
-			if i != len(s)-1 {
-				toWrite = append(toWrite, []byte("["+p.prefix+"] ")...)
-			} else {
-				p.lastWroteNewline = true
-			}
-		}
-	}
-	if _, err := p.writer.Write(toWrite); err != nil {
-		return 0, err
-	}
-	return len(b), nil
-}
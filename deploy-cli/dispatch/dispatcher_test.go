@@ -0,0 +1,31 @@
+package dispatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserQualifiedHostname(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		expected UserQualifiedHostname
+	}{
+		{"pi-master", UserQualifiedHostname{FQDN: "pi-master"}},
+		{"pi@192.168.1.10", UserQualifiedHostname{User: "pi", FQDN: "192.168.1.10"}},
+		{"jump@bastion.example.com:2222", UserQualifiedHostname{User: "jump", FQDN: "bastion.example.com", Port: "2222"}},
+	} {
+		var uqhn UserQualifiedHostname
+		_, err := uqhn.ParseString(tc.in)
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.expected, uqhn)
+		require.Equal(t, tc.in, uqhn.String())
+	}
+	_, err := (&UserQualifiedHostname{}).ParseString("bastion:port")
+	require.Error(t, err)
+
+	node := Node{Name: "pi-master", Remote: UserQualifiedHostname{FQDN: "pi-master"}}
+	require.Equal(t, "pi-master", node.Address())
+	node.Hostname = "192.168.1.10"
+	require.Equal(t, "192.168.1.10", node.Address())
+}
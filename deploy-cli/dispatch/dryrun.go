@@ -0,0 +1,148 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/kev-cao/log-console/deploy-cli/transcript"
+)
+
+// DryRunDispatcher is a ClusterDispatcher that prints every remote action it's
+// asked to carry out instead of carrying it out. The wrapped dispatcher is only
+// used to look up the cluster's nodes, so it doesn't need to be connected.
+type DryRunDispatcher struct {
+	dispatcher ClusterDispatcher
+	outputs    cannedOutputs
+}
+
+// cannedOutput is the output a command matching a pattern pretends to write,
+// for commands whose output is parsed by the caller.
+type cannedOutput struct {
+	pattern *regexp.Regexp
+	stdout  string
+}
+
+type cannedOutputs []cannedOutput
+
+// write writes the output of the first canned output matching the command to
+// its stdout.
+func (c cannedOutputs) write(cmd Command) error {
+	for _, output := range c {
+		if output.pattern.MatchString(cmd.Cmd()) {
+			_, err := io.WriteString(cmd.Stdout(), output.stdout)
+			return err
+		}
+	}
+	return nil
+}
+
+var _ ClusterDispatcher = (*DryRunDispatcher)(nil)
+
+// NewDryRunDispatcher creates a DryRunDispatcher for the nodes of a dispatcher.
+func NewDryRunDispatcher(d ClusterDispatcher) *DryRunDispatcher {
+	return &DryRunDispatcher{dispatcher: d}
+}
+
+// Script makes commands matching the pattern write stdout. Commands that don't
+// match any script write nothing.
+func (d *DryRunDispatcher) Script(pattern string, stdout string) {
+	d.outputs = append(d.outputs, cannedOutput{regexp.MustCompile(pattern), stdout})
+}
+
+// Unwrap returns the wrapped dispatcher.
+func (d *DryRunDispatcher) Unwrap() ClusterDispatcher {
+	return d.dispatcher
+}
+
+func (d *DryRunDispatcher) GetNodes() []Node {
+	return d.dispatcher.GetNodes()
+}
+
+func (d *DryRunDispatcher) GetMasterNode() Node {
+	return d.dispatcher.GetMasterNode()
+}
+
+func (d *DryRunDispatcher) GetWorkerNodes() []Node {
+	return d.dispatcher.GetWorkerNodes()
+}
+
+func (d *DryRunDispatcher) Ready() bool {
+	return true
+}
+
+func (d *DryRunDispatcher) Status(_ context.Context) []NodeStatus {
+	var statuses []NodeStatus
+	for _, node := range d.GetNodes() {
+		statuses = append(statuses, NodeStatus{Node: node, Reachable: true, State: "dry run"})
+	}
+	return statuses
+}
+
+func (d *DryRunDispatcher) SendCommands(node Node, cmds ...Command) error {
+	return d.SendCommandsContext(context.Background(), node, cmds...)
+}
+
+func (d *DryRunDispatcher) SendCommandsContext(_ context.Context, node Node, cmds ...Command) error {
+	for _, cmd := range cmds {
+		d.print(node, "would run: "+cmd.Line())
+		if err := d.outputs.write(cmd); err != nil {
+			return err
+		}
+		Flush(cmd.Stdout(), cmd.Stderr())
+	}
+	return nil
+}
+
+func (d *DryRunDispatcher) SendFile(node Node, src, dst string) error {
+	d.print(node, fmt.Sprintf("would copy %s to %s", src, dst))
+	return nil
+}
+
+func (d *DryRunDispatcher) DownloadProject(node Node, source string) error {
+	d.print(node, fmt.Sprintf("would download project from %s", source))
+	return nil
+}
+
+// LaunchNodes prints the nodes that would be launched, if the wrapped dispatcher
+// launches nodes.
+func (d *DryRunDispatcher) LaunchNodes() error {
+	if _, ok := d.dispatcher.(interface{ LaunchNodes() error }); !ok {
+		return errors.ErrUnsupported
+	}
+	for _, node := range d.GetNodes() {
+		d.print(node, "would launch node")
+	}
+	return nil
+}
+
+// Teardown prints the nodes that would be torn down, if the wrapped dispatcher
+// tears down its nodes itself.
+func (d *DryRunDispatcher) Teardown() error {
+	if _, ok := d.dispatcher.(interface{ Teardown() error }); !ok {
+		return errors.ErrUnsupported
+	}
+	for _, node := range d.GetNodes() {
+		d.print(node, "would stop and delete node")
+	}
+	return nil
+}
+
+func (d *DryRunDispatcher) Cleanup() error {
+	return d.dispatcher.Cleanup()
+}
+
+// print writes an action for a node, one output line per line of the action,
+// e.g. for commands with heredocs. Secrets are redacted.
+func (d *DryRunDispatcher) print(node Node, action string) {
+	for i, line := range strings.Split(transcript.Redact(action), "\n") {
+		if i > 0 {
+			line = "    " + line
+		}
+		Output.WriteLine(node.Name, os.Stdout, line)
+	}
+}
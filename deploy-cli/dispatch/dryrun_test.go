@@ -0,0 +1,41 @@
+package dispatch
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDispatcher is a ClusterDispatcher that only knows its nodes.
+type fakeDispatcher struct {
+	ClusterDispatcher
+	nodes []Node
+}
+
+func (f fakeDispatcher) GetNodes() []Node {
+	return f.nodes
+}
+
+func (f fakeDispatcher) Cleanup() error {
+	return nil
+}
+
+func TestDryRunDispatcher(t *testing.T) {
+	node := Node{Name: "master"}
+	d := NewDryRunDispatcher(fakeDispatcher{nodes: []Node{node}})
+	d.Script(`node-token`, "<token>\n")
+
+	var token, other bytes.Buffer
+	require.NoError(t, d.SendCommands(
+		node,
+		NewCommand("sudo cat /var/lib/rancher/k3s/server/node-token", WithStdout(&token)),
+		NewCommand("kubectl get nodes", WithStdout(&other)),
+	))
+	require.Equal(t, "<token>\n", token.String())
+	require.Empty(t, other.String())
+
+	// Dispatchers without a custom teardown fall back to the generic one.
+	require.ErrorIs(t, d.Teardown(), errors.ErrUnsupported)
+}
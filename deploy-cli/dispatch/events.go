@@ -0,0 +1,97 @@
+package dispatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// OutputFormat is the format that progress and node output is written in.
+type OutputFormat string
+
+const (
+	// OutputText is human-readable output with colors and live progress lines.
+	OutputText OutputFormat = "text"
+	// OutputPlain is human-readable output without any ANSI escape codes.
+	OutputPlain OutputFormat = "plain"
+	// OutputJSON is newline-delimited JSON events.
+	OutputJSON OutputFormat = "json"
+)
+
+type EventType string
+
+const (
+	EventStepStarted  EventType = "step_started"
+	EventStepFinished EventType = "step_finished"
+	EventOutput       EventType = "output"
+	EventMessage      EventType = "message"
+	EventNodeStatus   EventType = "node_status"
+	EventPlanItem     EventType = "plan_item"
+	EventError        EventType = "error"
+)
+
+// Event is a single line of output in the json output format.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type EventType `json:"type"`
+	// Step is the name of the step for step events.
+	Step string `json:"step,omitempty"`
+	// Node is the name of the node that output was written by.
+	Node string `json:"node,omitempty"`
+	// Stream is either stdout or stderr for output events.
+	Stream string `json:"stream,omitempty"`
+	// Line is a single line of output for output events.
+	Line string `json:"line,omitempty"`
+	// Level is the log level of message events.
+	Level string `json:"level,omitempty"`
+	// Message is the text of message events.
+	Message string `json:"message,omitempty"`
+	// Attrs are the attributes of message and plan item events.
+	Attrs map[string]any `json:"attrs,omitempty"`
+	// Status is the health report of a node for node status events.
+	Status *NodeStatus `json:"status,omitempty"`
+	// Error is the error message for error events and failed steps.
+	Error string `json:"error,omitempty"`
+}
+
+// Emit writes an event as a line of JSON. Events are only written in the json
+// output format.
+func (m *Multiplexer) Emit(e Event) error {
+	if m.Format != OutputJSON {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.emit(e)
+}
+
+// emit must be called with mu held.
+func (m *Multiplexer) emit(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = m.events.Write(append(b, '\n'))
+	return err
+}
+
+// Highlight wraps s in the ANSI color if the output format allows escape codes.
+func (m *Multiplexer) Highlight(s string, color string) string {
+	if m.Format != OutputText {
+		return s
+	}
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", color, s)
+}
+
+// streamName returns the name of the stream that w writes to for output events.
+func streamName(w io.Writer) string {
+	if w == os.Stderr {
+		return "stderr"
+	}
+	return "stdout"
+}
@@ -0,0 +1,145 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// LogHandler is a slog.Handler that writes records at or above its level to the
+// screen through a Multiplexer, formatted for the multiplexer's output format,
+// and every record to the multiplexer's log file if one is set.
+type LogHandler struct {
+	mux   *Multiplexer
+	level slog.Leveler
+	file  slog.Handler
+	// attrs are the attributes added with WithAttrs, with their keys qualified
+	// by the groups they were added in.
+	attrs []slog.Attr
+	group string
+}
+
+var _ slog.Handler = (*LogHandler)(nil)
+
+// NewLogHandler creates a LogHandler that writes records at or above the level
+// to the screen.
+func NewLogHandler(mux *Multiplexer, level slog.Leveler) *LogHandler {
+	return &LogHandler{mux: mux, level: level, file: mux.file}
+}
+
+func (h *LogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level() || h.file != nil
+}
+
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if h.file != nil {
+		if err := h.file.Handle(ctx, r); err != nil {
+			return err
+		}
+	}
+	if r.Level < h.level.Level() {
+		return nil
+	}
+	attrs := slices.Clone(h.attrs)
+	r.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, h.qualify(attr))
+		return true
+	})
+	return h.mux.writeRecord(r.Level, r.Message, attrs)
+}
+
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = slices.Clone(h.attrs)
+	for _, attr := range attrs {
+		clone.attrs = append(clone.attrs, h.qualify(attr))
+	}
+	if h.file != nil {
+		clone.file = h.file.WithAttrs(attrs)
+	}
+	return &clone
+}
+
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.group = h.group + name + "."
+	if h.file != nil {
+		clone.file = h.file.WithGroup(name)
+	}
+	return &clone
+}
+
+func (h *LogHandler) qualify(attr slog.Attr) slog.Attr {
+	attr.Key = h.group + attr.Key
+	return attr
+}
+
+// SetLogFile writes every log record and every line of node output to w,
+// including output that is hidden from the screen. A nil w stops writing to the
+// previous log file.
+func (m *Multiplexer) SetLogFile(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if w == nil {
+		m.file = nil
+		return
+	}
+	m.file = slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+}
+
+// logLine writes a line of node output to the log file. Must be called with mu
+// held.
+func (m *Multiplexer) logLine(prefix string, w io.Writer, line string) error {
+	if m.file == nil {
+		return nil
+	}
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, line, 0)
+	r.AddAttrs(slog.String("node", prefix), slog.String("stream", streamName(w)))
+	return m.file.Handle(context.Background(), r)
+}
+
+// writeRecord writes a log record to the screen.
+func (m *Multiplexer) writeRecord(level slog.Level, msg string, attrs []slog.Attr) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Format == OutputJSON {
+		event := Event{Type: EventMessage, Level: level.String(), Message: msg}
+		if len(attrs) > 0 {
+			event.Attrs = make(map[string]any, len(attrs))
+			for _, attr := range attrs {
+				event.Attrs[attr.Key] = logValue(attr.Value)
+			}
+		}
+		return m.emit(event)
+	}
+	var b strings.Builder
+	// Info records are what the CLI normally prints, so they aren't labeled.
+	if level != slog.LevelInfo {
+		b.WriteString(level.String() + " ")
+	}
+	b.WriteString(msg)
+	for _, attr := range attrs {
+		fmt.Fprintf(&b, " %s=%v", attr.Key, logValue(attr.Value))
+	}
+	_, err := fmt.Fprintln(m.events, b.String())
+	return err
+}
+
+// logValue converts a log value to a value that can be printed or encoded as
+// JSON.
+func logValue(v slog.Value) any {
+	v = v.Resolve()
+	switch v.Kind() {
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+	}
+	return v.Any()
+}
@@ -0,0 +1,114 @@
+package multipass
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotInstalled is returned when the multipass executable cannot be found.
+	ErrNotInstalled = errors.New("multipass is not installed")
+	// ErrDaemonUnavailable is returned when the multipass daemon cannot be reached.
+	ErrDaemonUnavailable = errors.New("multipass daemon is not running")
+	// ErrInstanceNotFound is returned when querying an instance that does not exist.
+	ErrInstanceNotFound = errors.New("instance does not exist")
+)
+
+// client runs multipass commands and decodes their JSON output into typed
+// structs, so that no other tools (e.g. jq) are needed on the host.
+type client struct {
+	// executable is the multipass binary to run. Defaults to multipass on the PATH.
+	executable string
+}
+
+// instanceInfo is an instance as reported by `multipass info --format json`.
+type instanceInfo struct {
+	State string   `json:"state"`
+	Ipv4  []string `json:"ipv4"`
+}
+
+// command creates an exec.Cmd for the multipass executable.
+func (c client) command(ctx context.Context, args ...string) *exec.Cmd {
+	executable := c.executable
+	if executable == "" {
+		executable = "multipass"
+	}
+	slog.Debug("running multipass", "args", args)
+	cmd := exec.CommandContext(ctx, executable, args...)
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+// run runs a multipass command and returns its stdout. Failures are classified
+// into the typed errors above where possible.
+func (c client) run(ctx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := c.command(ctx, args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err == nil {
+		return output, nil
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotInstalled
+	}
+	msg := strings.TrimSpace(stderr.String())
+	switch {
+	case strings.Contains(msg, "does not exist"):
+		return nil, fmt.Errorf("%w: %s", ErrInstanceNotFound, msg)
+	case strings.Contains(msg, "multipass socket"), strings.Contains(msg, "multipassd"):
+		return nil, fmt.Errorf("%w: %s", ErrDaemonUnavailable, msg)
+	case msg != "":
+		return nil, fmt.Errorf("multipass %s failed: %s", args[0], msg)
+	default:
+		return nil, fmt.Errorf("multipass %s failed: %w", args[0], err)
+	}
+}
+
+// Info returns information about a single instance.
+func (c client) Info(ctx context.Context, name string) (instanceInfo, error) {
+	output, err := c.run(ctx, "info", name, "--format", "json")
+	if err != nil {
+		return instanceInfo{}, err
+	}
+	var info struct {
+		Info map[string]instanceInfo `json:"info"`
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return instanceInfo{}, fmt.Errorf("could not parse multipass info: %w", err)
+	}
+	instance, ok := info.Info[name]
+	if !ok {
+		return instanceInfo{}, fmt.Errorf("%w: %s", ErrInstanceNotFound, name)
+	}
+	return instance, nil
+}
+
+// snapshotInfo is a snapshot as reported by `multipass list --snapshots --format json`.
+type snapshotInfo struct {
+	Comment string `json:"comment"`
+}
+
+// Snapshots returns the snapshots of every instance, keyed by instance name and
+// then snapshot name.
+func (c client) Snapshots(ctx context.Context) (map[string]map[string]snapshotInfo, error) {
+	output, err := c.run(ctx, "list", "--snapshots", "--format", "json")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Info map[string]map[string]snapshotInfo `json:"info"`
+	}
+	if err := json.Unmarshal(output, &list); err != nil {
+		return nil, fmt.Errorf("could not parse multipass snapshots: %w", err)
+	}
+	return list.Info, nil
+}
@@ -0,0 +1,150 @@
+package multipass
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMultipass writes a fake multipass executable that runs the given shell
+// script body and returns its path.
+func fakeMultipass(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "multipass")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755))
+	return path
+}
+
+func TestClientInfo(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("decodes instance", func(t *testing.T) {
+		c := client{executable: fakeMultipass(t, `echo '{
+			"errors": [],
+			"info": {"master": {"state": "Running", "ipv4": ["10.0.0.2"], "release": "Ubuntu 24.04 LTS"}}
+		}'`)}
+		info, err := c.Info(ctx, "master")
+		require.NoError(t, err)
+		require.Equal(t, "Running", info.State)
+		require.Equal(t, []string{"10.0.0.2"}, info.Ipv4)
+	})
+
+	t.Run("instance does not exist", func(t *testing.T) {
+		c := client{executable: fakeMultipass(t, `echo 'info failed: instance "master" does not exist' >&2; exit 2`)}
+		_, err := c.Info(ctx, "master")
+		require.ErrorIs(t, err, ErrInstanceNotFound)
+	})
+
+	t.Run("daemon not running", func(t *testing.T) {
+		c := client{executable: fakeMultipass(t, `echo 'cannot connect to the multipass socket' >&2; exit 2`)}
+		_, err := c.Info(ctx, "master")
+		require.ErrorIs(t, err, ErrDaemonUnavailable)
+	})
+
+	t.Run("not installed", func(t *testing.T) {
+		c := client{executable: filepath.Join(t.TempDir(), "multipass")}
+		_, err := c.Info(ctx, "master")
+		require.ErrorIs(t, err, ErrNotInstalled)
+	})
+}
+
+func TestDispatcherReady(t *testing.T) {
+	executable := fakeMultipass(t, `
+case "$2" in
+	master) echo '{"info": {"master": {"state": "Running", "ipv4": ["10.0.0.2"]}}}' ;;
+	worker-1) echo '{"info": {"worker-1": {"state": "Stopped", "ipv4": []}}}' ;;
+	*) echo "info failed: instance \"$2\" does not exist" >&2; exit 2 ;;
+esac`)
+
+	m := MultipassDispatcher{NumNodes: 1, MasterName: "master", WorkerName: "worker", Executable: executable}
+	require.NoError(t, m.maybeGenerateNodes())
+	require.Equal(t, "10.0.0.2", m.GetMasterNode().Remote.FQDN)
+	require.True(t, m.Ready())
+
+	m.NumNodes = 3
+	require.NoError(t, m.maybeGenerateNodes())
+	require.Equal(t, "worker-2", m.GetWorkerNodes()[1].Kubename)
+	require.False(t, m.Ready())
+}
+
+func TestListSnapshots(t *testing.T) {
+	executable := fakeMultipass(t, `echo '{
+		"errors": [],
+		"info": {
+			"master": {
+				"base": {"comment": "deploy-cli cluster snapshot", "parent": ""},
+				"vault": {"comment": "deploy-cli cluster snapshot", "parent": "base"},
+				"manual": {"comment": "", "parent": "vault"}
+			},
+			"worker-1": {"base": {"comment": "deploy-cli cluster snapshot", "parent": ""}},
+			"other": {"base": {"comment": "deploy-cli cluster snapshot", "parent": ""}}
+		}
+	}'`)
+	m := MultipassDispatcher{NumNodes: 2, MasterName: "master", WorkerName: "worker", Executable: executable}
+	snapshots, err := m.ListSnapshots()
+	require.NoError(t, err)
+	require.Equal(t, []Snapshot{
+		{Name: "base", Nodes: []string{"master", "worker-1"}, Complete: true},
+		{Name: "vault", Nodes: []string{"master"}, Complete: false},
+	}, snapshots)
+}
+
+func TestSaveSnapshotFailure(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	executable := fakeMultipass(t, `
+echo "$@" >> `+log+`
+case "$1" in
+	list) echo '{"errors": [], "info": {}}' ;;
+	info) echo "{\"info\": {\"$2\": {\"state\": \"Running\", \"ipv4\": []}}}" ;;
+	snapshot) echo 'snapshot failed: disk full' >&2; exit 2 ;;
+esac`)
+	m := MultipassDispatcher{NumNodes: 2, MasterName: "master", WorkerName: "worker", Executable: executable}
+	require.ErrorContains(t, m.SaveSnapshot("base"), "error snapshotting master")
+	b, err := os.ReadFile(log)
+	require.NoError(t, err)
+	// The nodes are started again after the failure.
+	require.Contains(t, string(b), "start master\nstart worker-1\n")
+}
+
+func TestAddWorkersFailure(t *testing.T) {
+	executable := fakeMultipass(t, `
+case "$1" in
+	launch) echo 'launch failed: not enough memory' >&2; exit 2 ;;
+	info) echo "{\"info\": {\"$2\": {\"state\": \"Running\", \"ipv4\": []}}}" ;;
+esac`)
+	m := MultipassDispatcher{NumNodes: 2, MasterName: "master", WorkerName: "worker", Executable: executable}
+	require.NoError(t, m.maybeGenerateNodes())
+	_, err := m.AddWorkers(2)
+	require.Error(t, err)
+	// The cluster still only has the nodes that were up.
+	require.Equal(t, 2, m.NumNodes)
+	require.Len(t, m.GetWorkerNodes(), 1)
+}
+
+func TestNewMultipassDispatcher(t *testing.T) {
+	executable := fakeMultipass(t, `
+echo 'info failed: cannot connect to the multipass socket' >&2
+exit 2`)
+	t.Setenv("PATH", filepath.Dir(executable))
+	_, err := NewMultipassDispatcher(2, "", "master", "worker")
+	require.ErrorIs(t, err, ErrDaemonUnavailable)
+}
+
+func TestTeardownPartialLaunch(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "log")
+	executable := fakeMultipass(t, `
+case "$1 $2" in
+	"info master") echo '{"info": {"master": {"state": "Running", "ipv4": ["10.0.0.2"]}}}' ;;
+	"info worker-1") echo '{"info": {"worker-1": {"state": "Deleted", "ipv4": []}}}' ;;
+	info*) echo "info failed: instance \"$2\" does not exist" >&2; exit 2 ;;
+	*) echo "$@" >> `+log+` ;;
+esac`)
+	m := MultipassDispatcher{NumNodes: 3, MasterName: "master", WorkerName: "worker", Executable: executable}
+	require.NoError(t, m.Teardown())
+	b, err := os.ReadFile(log)
+	require.NoError(t, err)
+	// The worker that was never launched isn't stopped or deleted.
+	require.Equal(t, "stop --force master\ndelete --purge master worker-1\n", string(b))
+}
@@ -3,164 +3,348 @@ package multipass
 import (
 	"bytes"
 	"context"
+	_ "embed"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/kev-cao/log-console/deploy-cli/dispatch"
 	"github.com/kev-cao/log-console/utils/pathutils"
 	"github.com/kev-cao/log-console/utils/sliceutils"
-	"github.com/kev-cao/log-console/utils/stringutils"
 	"golang.org/x/sync/errgroup"
 )
 
 type MultipassDispatcher struct {
-	NumNodes   int
-	MasterName string
+	NumNodes int
+	// ClusterName prefixes the names of the cluster's VMs, e.g. $ClusterName-master,
+	// so that multiple clusters can run side by side. No prefix is used if empty.
+	ClusterName string
+	MasterName  string
 	// Workers will be named $WorkerName-1, $WorkerName-2, ...
 	WorkerName  string
 	MasterNode  dispatch.Node
 	WorkerNodes []dispatch.Node
+	// MasterSpec and WorkerSpec are the VM configurations used when launching
+	// the master and worker nodes respectively.
+	MasterSpec NodeSpec
+	WorkerSpec NodeSpec
+	// CloudInit is a text/template for the cloud-init user-data passed to each
+	// node on launch. It is rendered per node with cloudInitData. No user-data
+	// is passed if empty.
+	CloudInit string
+	// AuthorizedKeys are public keys made available to the cloud-init template.
+	AuthorizedKeys []string
+	// Executable is the multipass binary to run. Defaults to multipass on the PATH.
+	Executable string
+}
+
+//go:embed static/cloud-init.yaml
+var DefaultCloudInit string
+
+// cloudInitData is the data the cloud-init template is rendered with.
+type cloudInitData struct {
+	Name           string
+	Role           string
+	AuthorizedKeys []string
+}
+
+// renderCloudInit renders the cloud-init template for a node.
+func (m MultipassDispatcher) renderCloudInit(name, role string) (string, error) {
+	tmpl, err := template.New("cloud-init").Option("missingkey=error").Parse(m.CloudInit)
+	if err != nil {
+		return "", fmt.Errorf("invalid cloud-init template: %w", err)
+	}
+	var userData strings.Builder
+	if err := tmpl.Execute(&userData, cloudInitData{
+		Name:           name,
+		Role:           role,
+		AuthorizedKeys: m.AuthorizedKeys,
+	}); err != nil {
+		return "", fmt.Errorf("error rendering cloud-init for %s: %w", name, err)
+	}
+	return userData.String(), nil
+}
+
+// NodeSpec is the VM configuration for a multipass node.
+type NodeSpec struct {
+	Cpus int
+	// Memory and Disk are sizes in multipass format, e.g. 2G.
+	Memory string
+	Disk   string
+	// Image is the Ubuntu image or release to launch, e.g. 24.04 or noble. Uses
+	// the multipass default if empty.
+	Image string
+	// Network is the name of the host network to bridge the node to. The node is
+	// only attached to the default network if empty.
+	Network string
+}
+
+// DefaultNodeSpec is the VM configuration used for nodes without a spec.
+var DefaultNodeSpec = NodeSpec{Cpus: 2, Memory: "2G", Disk: "20G"}
+
+// launchArgs returns the `multipass launch` arguments for the spec. Unset values
+// fall back to DefaultNodeSpec.
+func (s NodeSpec) launchArgs() []string {
+	if s.Cpus <= 0 {
+		s.Cpus = DefaultNodeSpec.Cpus
+	}
+	if s.Memory == "" {
+		s.Memory = DefaultNodeSpec.Memory
+	}
+	if s.Disk == "" {
+		s.Disk = DefaultNodeSpec.Disk
+	}
+	args := []string{"--cpus", strconv.Itoa(s.Cpus), "--memory", s.Memory, "--disk", s.Disk}
+	if s.Network != "" {
+		args = append(args, "--network", s.Network)
+	}
+	if s.Image != "" {
+		args = append(args, s.Image)
+	}
+	return args
 }
 
 var _ dispatch.ClusterDispatcher = &MultipassDispatcher{}
 
-func NewMultipassDispatcher(numNodes int, masterName, workerName string) *MultipassDispatcher {
+func NewMultipassDispatcher(numNodes int, clusterName, masterName, workerName string) (*MultipassDispatcher, error) {
 	dispatcher := &MultipassDispatcher{
-		NumNodes:   numNodes,
-		MasterName: masterName,
-		WorkerName: workerName,
-	}
-	dispatcher.MasterNode = dispatch.Node{Name: masterName}
-	for i := 1; i < numNodes; i++ {
-		dispatcher.WorkerNodes = append(
-			dispatcher.WorkerNodes,
-			dispatch.Node{
-				Name: fmt.Sprintf("%s-%d", workerName, i),
-			},
-		)
+		NumNodes:    numNodes,
+		ClusterName: clusterName,
+		MasterName:  masterName,
+		WorkerName:  workerName,
+	}
+	// Nodes that aren't launched yet are still generated, just without addresses.
+	if err := dispatcher.maybeGenerateNodes(); err != nil {
+		return nil, err
 	}
-	dispatcher.maybeGenerateNodes()
-	return dispatcher
+	return dispatcher, nil
+}
+
+// client returns the multipass client used by the dispatcher.
+func (m MultipassDispatcher) client() client {
+	return client{executable: m.Executable}
 }
 
+// maybeGenerateNodes generates the cluster's nodes from the dispatcher's
+// configuration and fills in the address of each node that has been launched.
 func (m *MultipassDispatcher) maybeGenerateNodes() error {
+	names := m.generateNodeNames()
+	nodes := make([]dispatch.Node, len(names))
 	var wg errgroup.Group
-	for idx, node := range m.GetNodes() {
+	for idx, name := range names {
+		nodes[idx] = dispatch.Node{Name: name, Kubename: "master"}
+		if idx > 0 {
+			nodes[idx].Kubename = fmt.Sprintf("worker-%d", idx)
+		}
 		wg.Go(func() error {
-			// Check if node exists (could be not launched yet)
-			cmd := exec.Command("multipass", "info", node.Name, "--format", "json")
-			stdout := bytes.NewBuffer([]byte{})
-			cmd.Stdout = stdout
-			if err := cmd.Run(); err != nil {
-				// If node does not exist, we cannot generate its node
+			info, err := m.client().Info(context.Background(), name)
+			if errors.Is(err, ErrInstanceNotFound) {
+				// Node has not been launched yet, so it has no address
 				return nil
-			}
-			// Get IP address of the node
-			cmd = exec.Command(
-				"bash", "-c",
-				fmt.Sprintf(
-					`multipass info %s --format json | jq -r '.info.["%s"].ipv4[0]'`,
-					node.Name, node.Name,
-				),
-			)
-			cmd.Stderr = dispatch.NewPrefixWriter(node.Name, os.Stderr)
-			cmd.WaitDelay = 5 * time.Second
-			ipBytes, err := cmd.Output()
-			if err != nil {
+			} else if err != nil {
 				return err
 			}
-			node.Remote.User = "ubuntu" // multipass by default uses ubuntu user
-			node.Remote.FQDN = strings.TrimSpace(string(ipBytes))
-			if idx == 0 {
-				m.MasterNode = node
-			} else {
-				m.WorkerNodes = append(m.WorkerNodes, node)
+			if len(info.Ipv4) > 0 {
+				nodes[idx].Remote = dispatch.UserQualifiedHostname{
+					User: "ubuntu", // multipass by default uses ubuntu user
+					FQDN: info.Ipv4[0],
+				}
 			}
 			return nil
 		})
 	}
-	return wg.Wait()
+	err := wg.Wait()
+	m.MasterNode, m.WorkerNodes = nodes[0], nodes[1:]
+	return err
 }
 
 func (m *MultipassDispatcher) LaunchNodes() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	if err := m.launch(m.generateNodeNames()); err != nil {
+		return err
+	}
+	return m.maybeGenerateNodes()
+}
+
+// AddWorkers launches n new worker nodes and adds them to the cluster. Returns
+// the new nodes.
+func (m *MultipassDispatcher) AddWorkers(n int) ([]dispatch.Node, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var names []string
+	for i := m.NumNodes; i < m.NumNodes+n; i++ {
+		names = append(names, m.prefixed(fmt.Sprintf("%s-%d", m.WorkerName, i)))
+	}
+	if err := m.launch(names); err != nil {
+		return nil, err
+	}
+	// The new nodes are only part of the cluster once they're up.
+	m.NumNodes += n
+	if err := m.maybeGenerateNodes(); err != nil {
+		return nil, err
+	}
+	workers := m.GetWorkerNodes()
+	return workers[len(workers)-n:], nil
+}
+
+// RemoveWorkers deletes the last n worker nodes and removes them from the
+// cluster. Returns the removed nodes.
+func (m *MultipassDispatcher) RemoveWorkers(n int) ([]dispatch.Node, error) {
+	workers := m.GetWorkerNodes()
+	if n <= 0 {
+		return nil, nil
+	} else if n > len(workers) {
+		return nil, fmt.Errorf("cannot remove %d workers from a cluster with %d workers", n, len(workers))
+	}
+	removed := workers[len(workers)-n:]
+	names := sliceutils.Map(removed, func(node dispatch.Node, _ int) string {
+		return node.Name
+	})
+	if err := m.runNodeCommand(append([]string{"delete", "--purge"}, names...)...); err != nil {
+		return nil, fmt.Errorf("error deleting nodes: %w", err)
+	}
+	m.NumNodes -= n
+	return removed, m.maybeGenerateNodes()
+}
+
+// launch launches the named nodes in parallel. The master node is launched as
+// the master and the rest as workers.
+func (m *MultipassDispatcher) launch(nodeNames []string) error {
+	// Launching waits for cloud-init to finish, which can take a while if it
+	// installs packages.
+	ctx, cancel := context.WithTimeout(context.Background(), launchTimeout+time.Minute)
 	defer cancel()
-	nodeNames := m.generateNodeNames()
 	var wg errgroup.Group
-	stdoutWriter := newLaunchWriter(os.Stdout)
-	for _, name := range nodeNames {
-		node := dispatch.Node{Name: name, Kubename: name}
-		nodeWriter := stdoutWriter.newNodeWriter(&node)
+	nodes := sliceutils.Map(nodeNames, func(name string, _ int) dispatch.Node {
+		return dispatch.Node{Name: name, Kubename: name}
+	})
+	progress := dispatch.NewProgressRenderer(os.Stdout, nodes)
+	for _, node := range nodes {
+		spec, role := m.WorkerSpec, "worker"
+		if node.Name == m.generateNodeNames()[0] {
+			spec, role = m.MasterSpec, "master"
+		}
 		wg.Go(func() error {
 			stdErr := bytes.NewBuffer([]byte{})
-			cmd := exec.CommandContext(
-				ctx,
-				"multipass", "launch", "--name", node.Name, "--cpus", "2", "--memory", "2G", "--disk", "20G",
+			args := append(
+				[]string{
+					"launch",
+					"--name", node.Name,
+					"--timeout", strconv.Itoa(int(launchTimeout.Seconds())),
+				},
+				spec.launchArgs()...,
 			)
-			cmd.Stdout = nodeWriter
+			var userData string
+			if m.CloudInit != "" {
+				var err error
+				if userData, err = m.renderCloudInit(node.Name, role); err != nil {
+					return err
+				}
+				args = append(args, "--cloud-init", "-")
+			}
+			cmd := m.client().command(ctx, args...)
+			cmd.Stdin = strings.NewReader(userData)
+			cmd.Stdout = progress.Writer(node)
 			cmd.Stderr = stdErr
 			e := cmd.Run()
 			if e != nil {
-				stdoutWriter.setLine(
-					&node,
+				progress.SetStatus(
+					node,
 					fmt.Sprintf("Error launching node: %s", strings.TrimSpace(stdErr.String())),
 				)
 				return e
 			}
-			stdoutWriter.setLine(&node, "Node launched successfully!")
+			progress.SetStatus(node, "Node launched successfully!")
 			return nil
 		})
 	}
 	if err := wg.Wait(); err != nil {
 		return err
 	}
-	if err := m.maybeGenerateNodes(); err != nil {
-		return err
-	}
-	fmt.Println("Nodes are ready!")
+	slog.Info("Nodes are ready!")
 	return nil
 }
 
+// launchTimeout is how long multipass waits for a node to launch and finish
+// running cloud-init.
+const launchTimeout = 10 * time.Minute
+
 func (m MultipassDispatcher) Ready() bool {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	var wg sync.WaitGroup
-	ready := true
-	var mu sync.Mutex
+	var wg errgroup.Group
 	for _, node := range m.GetNodes() {
-		wg.Add(1)
-		go func(node dispatch.Node) {
-			defer wg.Done()
-			cmd := exec.CommandContext(
-				ctx,
-				"bash", "-c",
-				fmt.Sprintf(
-					`multipass info %s --format json | jq -r '.info.["%s"].state'`,
-					node.Name, node.Name,
-				),
-			)
-			cmd.WaitDelay = 5 * time.Second
-			state, err := cmd.Output()
-			// If ready is already set false, we don't need to check again
-			if !ready {
-				return
+		wg.Go(func() error {
+			info, err := m.client().Info(ctx, node.Name)
+			if err != nil {
+				return err
 			}
-			// If there was an error or the node is not running, set ready to false
-			if err != nil || !strings.Contains(string(state), "Running") {
-				mu.Lock()
-				defer mu.Unlock()
-				ready = false
-				cancel()
+			if info.State != "Running" {
+				return fmt.Errorf("%s is %s", node.Name, info.State)
 			}
-		}(node)
+			return nil
+		})
+	}
+	return wg.Wait() == nil
+}
+
+func (m MultipassDispatcher) Status(ctx context.Context) []dispatch.NodeStatus {
+	nodes := m.GetNodes()
+	statuses := make([]dispatch.NodeStatus, len(nodes))
+	var wg sync.WaitGroup
+	for idx, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[idx] = m.nodeStatus(ctx, node)
+		}()
 	}
 	wg.Wait()
-	return ready
+	return statuses
+}
+
+// nodeStatus checks the VM state of a single node and, if it is running, whether
+// commands can be executed on it.
+func (m MultipassDispatcher) nodeStatus(ctx context.Context, node dispatch.Node) dispatch.NodeStatus {
+	status := dispatch.NodeStatus{Node: node}
+	info, err := m.client().Info(ctx, node.Name)
+	if err != nil {
+		status.State = "Unknown"
+		status.Err = err
+		return status
+	}
+	status.State = info.State
+	if status.State != "Running" {
+		status.Err = errors.New("node is not running")
+		return status
+	}
+
+	var uptime strings.Builder
+	start := time.Now()
+	if err := m.SendCommandsContext(
+		ctx,
+		node,
+		dispatch.NewCommand(
+			dispatch.UptimeCmd,
+			dispatch.WithStdout(&uptime),
+			dispatch.WithTimeout(10*time.Second),
+		),
+	); err != nil {
+		status.Err = err
+		return status
+	}
+	status.Latency = time.Since(start)
+	status.Reachable = true
+	status.Uptime, status.Err = dispatch.ParseUptime(uptime.String())
+	return status
 }
 
 func (m MultipassDispatcher) GetNodes() []dispatch.Node {
@@ -191,22 +375,14 @@ func (m MultipassDispatcher) SendCommandsContext(
 			cmdCtx, cancel = context.WithTimeout(ctx, cmd.Timeout())
 			defer cancel()
 		}
-		command := exec.CommandContext(
-			cmdCtx, "multipass", "exec", node.Name, "--", "/bin/bash", "-c",
-			fmt.Sprintf("%s %s", stringutils.BuildEnvBindings(cmd.Env()), cmd.Cmd()),
+		command := m.client().command(
+			cmdCtx, "exec", node.Name, "--", "/bin/bash", "-c", cmd.Line(),
 		)
-		if cmd.Stdout() == nil {
-			command.Stdout = io.Discard
-		} else {
-			command.Stdout = cmd.Stdout()
-		}
-		if cmd.Stderr() == nil {
-			command.Stderr = io.Discard
-		} else {
-			command.Stderr = cmd.Stderr()
-		}
-
-		if err := command.Run(); err != nil {
+		if err := dispatch.RunCommand(node, cmd, func(stdout, stderr io.Writer) error {
+			command.Stdout = stdout
+			command.Stderr = stderr
+			return command.Run()
+		}); err != nil {
 			return err
 		}
 	}
@@ -214,11 +390,10 @@ func (m MultipassDispatcher) SendCommandsContext(
 }
 
 func (m MultipassDispatcher) SendFile(node dispatch.Node, src, dst string) error {
-	cmd := pipeOutputs(
-		exec.Command("multipass", "transfer", "--parents", src, node.Name+":"+dst),
+	return runPiped(
+		m.client().command(context.Background(), "transfer", "--parents", src, node.Name+":"+dst),
 		node.Name,
 	)
-	return cmd.Run()
 }
 
 func (m MultipassDispatcher) DownloadProject(node dispatch.Node, source string) error {
@@ -239,11 +414,12 @@ func (m MultipassDispatcher) DownloadProject(node dispatch.Node, source string)
 			return err
 		}
 		// unmount if it's already mounted
-		exec.Command("multipass", "umount", node.Name+":/home/ubuntu/projects/log-console").Run()
-		cmd := exec.Command("multipass", "mount", "--type=classic", path, node.Name+":/home/ubuntu/projects/log-console")
-		cmd.Stdout = dispatch.NewPrefixWriter(node.Name, os.Stdout)
-		cmd.Stderr = dispatch.NewPrefixWriter(node.Name, os.Stderr)
-		if err := cmd.Run(); err != nil {
+		m.client().command(context.Background(), "umount", node.Name+":/home/ubuntu/projects/log-console").Run()
+		cmd := m.client().command(
+			context.Background(),
+			"mount", "--type=classic", path, node.Name+":/home/ubuntu/projects/log-console",
+		)
+		if err := runPiped(cmd, node.Name); err != nil {
 			return err
 		}
 	} else {
@@ -268,32 +444,102 @@ func (m MultipassDispatcher) Cleanup() error {
 	return nil
 }
 
+// Teardown deletes and purges the cluster's nodes. Nodes that were never
+// launched, e.g. after a partial launch, are skipped. Other multipass instances,
+// including deleted instances of other clusters, are left untouched.
 func (m MultipassDispatcher) Teardown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
-	nodes := sliceutils.Map(m.GetNodes(), func(node dispatch.Node, _ int) string {
-		return node.Name
-	})
-	cmd := exec.CommandContext(ctx, "multipass", append([]string{"stop", "--force"}, nodes...)...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error stopping nodes: %w", err)
-	}
-	cmd = exec.CommandContext(ctx, "multipass", append([]string{"delete"}, nodes...)...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error deleting nodes: %w", err)
-	}
-
-	// Multipass purge occasionally fails if done immediately after delete
-	time.Sleep(1 * time.Second)
-	cmd = exec.CommandContext(ctx, "multipass", "purge")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error purging nodes: %w", err)
+	states, err := m.instanceStates(ctx)
+	if err != nil {
+		return fmt.Errorf("error finding nodes: %w", err)
+	}
+	var running, existing []string
+	for _, name := range m.generateNodeNames() {
+		state, ok := states[name]
+		if !ok {
+			continue
+		}
+		existing = append(existing, name)
+		// Deleted nodes can't be stopped, only purged.
+		if state != "Deleted" {
+			running = append(running, name)
+		}
+	}
+	if len(running) > 0 {
+		if err := runPiped(
+			m.client().command(ctx, append([]string{"stop", "--force"}, running...)...),
+			"multipass",
+		); err != nil {
+			return fmt.Errorf("error stopping nodes: %w", err)
+		}
+	}
+	if len(existing) > 0 {
+		if err := runPiped(
+			m.client().command(ctx, append([]string{"delete", "--purge"}, existing...)...),
+			"multipass",
+		); err != nil {
+			return fmt.Errorf("error deleting nodes: %w", err)
+		}
+	}
+	return nil
+}
+
+// instanceStates returns the state of each of the cluster's nodes that exists,
+// by name.
+func (m MultipassDispatcher) instanceStates(ctx context.Context) (map[string]string, error) {
+	var mu sync.Mutex
+	states := make(map[string]string)
+	var wg errgroup.Group
+	for _, name := range m.generateNodeNames() {
+		wg.Go(func() error {
+			info, err := m.client().Info(ctx, name)
+			if errors.Is(err, ErrInstanceNotFound) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			states[name] = info.State
+			return nil
+		})
+	}
+	return states, wg.Wait()
+}
+
+// Start starts every node in the cluster, resuming suspended nodes.
+func (m *MultipassDispatcher) Start() error {
+	if err := m.runOnNodes("start"); err != nil {
+		return err
+	}
+	// Nodes may be assigned new addresses when they start
+	return m.maybeGenerateNodes()
+}
+
+// Stop stops every node in the cluster.
+func (m *MultipassDispatcher) Stop() error {
+	return m.runOnNodes("stop")
+}
+
+// Suspend suspends every node in the cluster.
+func (m *MultipassDispatcher) Suspend() error {
+	return m.runOnNodes("suspend")
+}
+
+// Restart restarts every node in the cluster.
+func (m *MultipassDispatcher) Restart() error {
+	if err := m.runOnNodes("restart"); err != nil {
+		return err
+	}
+	return m.maybeGenerateNodes()
+}
+
+// runOnNodes runs a multipass command that takes instance names on every node
+// in the cluster.
+func (m MultipassDispatcher) runOnNodes(action string) error {
+	if err := m.runNodeCommand(append([]string{action}, m.generateNodeNames()...)...); err != nil {
+		return fmt.Errorf("error running %s on nodes: %w", action, err)
 	}
 	return nil
 }
@@ -302,17 +548,33 @@ func (m MultipassDispatcher) Teardown() error {
 // The first name is always the master node, and the rest are worker nodes.
 func (m MultipassDispatcher) generateNodeNames() []string {
 	var names []string
-	names = append(names, m.MasterName)
+	names = append(names, m.prefixed(m.MasterName))
 	for i := 1; i < m.NumNodes; i++ {
-		names = append(names, fmt.Sprintf("%s-%d", m.WorkerName, i))
+		names = append(names, m.prefixed(fmt.Sprintf("%s-%d", m.WorkerName, i)))
 	}
 	return names
 }
 
-// pipeOutputs pipes the command's stdout and stderr to the current process's stdout and stderr.
-// Mostly used for easily one-lining creating exec.Cmd and running.
-func pipeOutputs(cmd *exec.Cmd, prefix string) *exec.Cmd {
-	cmd.Stdout = dispatch.NewPrefixWriter(prefix, os.Stdout)
-	cmd.Stderr = dispatch.NewPrefixWriter(prefix, os.Stderr)
-	return cmd
+// prefixed prefixes a VM name with the cluster name, if there is one.
+func (m MultipassDispatcher) prefixed(name string) string {
+	if m.ClusterName == "" {
+		return name
+	}
+	return m.ClusterName + "-" + name
+}
+
+// runNodeCommand runs a multipass command, piping its output to the terminal.
+func (m MultipassDispatcher) runNodeCommand(args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	return runPiped(m.client().command(ctx, args...), "multipass")
+}
+
+// runPiped runs the command, piping its stdout and stderr to the current process's
+// stdout and stderr. Mostly used for easily one-lining creating exec.Cmd and running.
+func runPiped(cmd *exec.Cmd, prefix string) error {
+	cmd.Stdout = dispatch.Output.Writer(prefix, os.Stdout)
+	cmd.Stderr = dispatch.Output.Writer(prefix, os.Stderr)
+	defer dispatch.Flush(cmd.Stdout, cmd.Stderr)
+	return cmd.Run()
 }
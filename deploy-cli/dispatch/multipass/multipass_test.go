@@ -0,0 +1,60 @@
+package multipass
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLaunchArgs(t *testing.T) {
+	t.Run("empty spec uses defaults", func(t *testing.T) {
+		require.Equal(
+			t,
+			[]string{"--cpus", "2", "--memory", "2G", "--disk", "20G"},
+			NodeSpec{}.launchArgs(),
+		)
+	})
+
+	t.Run("full spec", func(t *testing.T) {
+		require.Equal(
+			t,
+			[]string{"--cpus", "4", "--memory", "8G", "--disk", "40G", "--network", "br0", "24.04"},
+			NodeSpec{Cpus: 4, Memory: "8G", Disk: "40G", Image: "24.04", Network: "br0"}.launchArgs(),
+		)
+	})
+}
+
+func TestRenderCloudInit(t *testing.T) {
+	m := MultipassDispatcher{
+		CloudInit:      DefaultCloudInit,
+		AuthorizedKeys: []string{"ssh-ed25519 AAAA test@host"},
+	}
+
+	master, err := m.renderCloudInit("master", "master")
+	require.NoError(t, err)
+	require.Contains(t, master, "hostname: master\n")
+	require.Contains(t, master, "  - ssh-ed25519 AAAA test@host\n")
+	require.Contains(t, master, "get-helm-3")
+
+	worker, err := m.renderCloudInit("worker-1", "worker")
+	require.NoError(t, err)
+	require.Contains(t, worker, "hostname: worker-1\n")
+	require.NotContains(t, worker, "get-helm-3")
+	require.Contains(t, worker, "cgroup_enable=memory")
+	var config map[string]any
+	require.NoError(t, yaml.Unmarshal([]byte(worker), &config))
+	require.Contains(t, config, "power_state")
+
+	m.CloudInit = "{{ .Missing }}"
+	_, err = m.renderCloudInit("master", "master")
+	require.Error(t, err)
+}
+
+func TestGenerateNodeNames(t *testing.T) {
+	m := MultipassDispatcher{NumNodes: 3, MasterName: "master", WorkerName: "worker"}
+	require.Equal(t, []string{"master", "worker-1", "worker-2"}, m.generateNodeNames())
+
+	m.ClusterName = "dev-a"
+	require.Equal(t, []string{"dev-a-master", "dev-a-worker-1", "dev-a-worker-2"}, m.generateNodeNames())
+}
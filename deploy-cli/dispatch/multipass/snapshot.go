@@ -0,0 +1,140 @@
+package multipass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// snapshotComment marks snapshots taken of the whole cluster by deploy-cli, so
+// that snapshots taken of single nodes by hand aren't listed as cluster
+// snapshots.
+const snapshotComment = "deploy-cli cluster snapshot"
+
+// Snapshot is a snapshot of the cluster's nodes.
+type Snapshot struct {
+	Name string
+	// Nodes are the names of the nodes that have the snapshot.
+	Nodes []string
+	// Complete is true if every node in the cluster has the snapshot.
+	Complete bool
+}
+
+// SaveSnapshot takes a snapshot of every node in the cluster. Nodes are stopped
+// before the snapshot so that it is consistent, and started again afterwards.
+func (m *MultipassDispatcher) SaveSnapshot(name string) error {
+	snapshots, err := m.ListSnapshots()
+	if err != nil {
+		return err
+	}
+	if idx := slices.IndexFunc(snapshots, func(s Snapshot) bool { return s.Name == name }); idx != -1 {
+		return fmt.Errorf("snapshot %s already exists", name)
+	}
+	return m.whileStopped(func() error {
+		for _, node := range m.generateNodeNames() {
+			if err := m.runNodeCommand(
+				"snapshot", "--name", name, "--comment", snapshotComment, node,
+			); err != nil {
+				return fmt.Errorf("error snapshotting %s: %w", node, err)
+			}
+		}
+		return nil
+	})
+}
+
+// RestoreSnapshot restores every node in the cluster to a snapshot. The
+// snapshot must exist on every node. The nodes' current state is discarded.
+func (m *MultipassDispatcher) RestoreSnapshot(name string) error {
+	snapshots, err := m.ListSnapshots()
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(snapshots, func(s Snapshot) bool { return s.Name == name })
+	if idx == -1 {
+		return fmt.Errorf("snapshot %s does not exist", name)
+	} else if !snapshots[idx].Complete {
+		return fmt.Errorf("snapshot %s only exists on %v", name, snapshots[idx].Nodes)
+	}
+	return m.whileStopped(func() error {
+		for _, node := range m.generateNodeNames() {
+			if err := m.runNodeCommand("restore", "--destructive", node+"."+name); err != nil {
+				return fmt.Errorf("error restoring %s: %w", node, err)
+			}
+		}
+		return nil
+	})
+}
+
+// whileStopped runs fn with the cluster's nodes stopped, and starts them again
+// afterwards even if fn fails, so that a failed snapshot doesn't leave the
+// cluster down.
+func (m *MultipassDispatcher) whileStopped(fn func() error) error {
+	if err := m.stopInOrder(); err != nil {
+		// Some nodes may have stopped before the failure.
+		return errors.Join(err, m.startInOrder())
+	}
+	return errors.Join(fn(), m.startInOrder())
+}
+
+// ListSnapshots returns the snapshots of the cluster's nodes, sorted by name.
+func (m MultipassDispatcher) ListSnapshots() ([]Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	instances, err := m.client().Snapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodeNames := m.generateNodeNames()
+	byName := make(map[string]*Snapshot)
+	for _, node := range nodeNames {
+		for name, info := range instances[node] {
+			if info.Comment != snapshotComment {
+				continue
+			}
+			if _, ok := byName[name]; !ok {
+				byName[name] = &Snapshot{Name: name}
+			}
+			byName[name].Nodes = append(byName[name].Nodes, node)
+		}
+	}
+	snapshots := make([]Snapshot, 0, len(byName))
+	for _, snapshot := range byName {
+		snapshot.Complete = len(snapshot.Nodes) == len(nodeNames)
+		snapshots = append(snapshots, *snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name < snapshots[j].Name })
+	return snapshots, nil
+}
+
+// stopInOrder stops the worker nodes before the master node so that the
+// workers don't lose the master while they're still running.
+func (m MultipassDispatcher) stopInOrder() error {
+	names := m.generateNodeNames()
+	if len(names) > 1 {
+		if err := m.runNodeCommand(append([]string{"stop"}, names[1:]...)...); err != nil {
+			return fmt.Errorf("error stopping worker nodes: %w", err)
+		}
+	}
+	if err := m.runNodeCommand("stop", names[0]); err != nil {
+		return fmt.Errorf("error stopping master node: %w", err)
+	}
+	return nil
+}
+
+// startInOrder starts the master node before the worker nodes so that the
+// workers can rejoin it.
+func (m *MultipassDispatcher) startInOrder() error {
+	names := m.generateNodeNames()
+	if err := m.runNodeCommand("start", names[0]); err != nil {
+		return fmt.Errorf("error starting master node: %w", err)
+	}
+	if len(names) > 1 {
+		if err := m.runNodeCommand(append([]string{"start"}, names[1:]...)...); err != nil {
+			return fmt.Errorf("error starting worker nodes: %w", err)
+		}
+	}
+	return m.maybeGenerateNodes()
+}
@@ -0,0 +1,180 @@
+package dispatch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Output is the multiplexer shared by every command that writes a node's output
+// to the terminal.
+var Output = NewMultiplexer()
+
+// ansiEscapePattern matches ANSI escape sequences, e.g. colors and cursor
+// movement, which are dropped from output in the plain and json formats.
+var ansiEscapePattern = regexp.MustCompile(`\x1B\[[0-9;?]*[a-zA-Z]`)
+
+// nodeColors are the ANSI colors assigned to node prefixes in the order that
+// nodes first write output.
+var nodeColors = []string{"36", "33", "35", "32", "34", "91", "96", "93", "95", "92"}
+
+// Multiplexer interleaves the output of several nodes. Each node's output is
+// buffered until a line is complete, and whole lines are written under a single
+// lock so that lines from nodes writing concurrently are never spliced together.
+type Multiplexer struct {
+	// Colors gives each node's prefix a distinct color when writing to a
+	// terminal. It is ignored if the NO_COLOR environment variable is set.
+	Colors bool
+	// Timestamps prefixes each line with the time it was written.
+	Timestamps bool
+	// Format is the format that output is written in. In the json format,
+	// every line is written as an output event instead.
+	Format OutputFormat
+	// Quiet hides node output from the screen. It is still written to the log
+	// file, if any.
+	Quiet bool
+	// events is where messages and events are written.
+	events io.Writer
+	// file is the handler of the log file, if any.
+	file   slog.Handler
+	mu     sync.Mutex
+	colors map[string]string
+}
+
+// NewMultiplexer creates a Multiplexer with colors enabled that writes text.
+func NewMultiplexer() *Multiplexer {
+	return &Multiplexer{
+		Colors: true,
+		Format: OutputText,
+		events: os.Stdout,
+		colors: make(map[string]string),
+	}
+}
+
+// Writer returns a writer that writes lines to w prefixed with the node's name.
+// Partial lines are held until they are completed or the writer is flushed.
+func (m *Multiplexer) Writer(prefix string, w io.Writer) *LineWriter {
+	return &LineWriter{mux: m, prefix: prefix, writer: w}
+}
+
+// WriteLine writes a single line to w prefixed with the node's name.
+func (m *Multiplexer) WriteLine(prefix string, w io.Writer, line string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writeLine(prefix, w, line)
+}
+
+// writeLine must be called with mu held.
+func (m *Multiplexer) writeLine(prefix string, w io.Writer, line string) error {
+	if m.Format != OutputText {
+		line = ansiEscapePattern.ReplaceAllString(line, "")
+	}
+	if err := m.logLine(prefix, w, ansiEscapePattern.ReplaceAllString(line, "")); err != nil {
+		return err
+	}
+	if m.Quiet {
+		return nil
+	}
+	if m.Format == OutputJSON {
+		return m.emit(Event{Type: EventOutput, Node: prefix, Stream: streamName(w), Line: line})
+	}
+	var buf bytes.Buffer
+	if m.Timestamps {
+		buf.WriteString(time.Now().Format("15:04:05.000 "))
+	}
+	if color := m.color(prefix, w); color != "" {
+		fmt.Fprintf(&buf, "\x1B[%sm[%s]\x1B[0m ", color, prefix)
+	} else {
+		fmt.Fprintf(&buf, "[%s] ", prefix)
+	}
+	buf.WriteString(line)
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// color returns the ANSI color of the node's prefix, or an empty string if the
+// prefix shouldn't be colored. Must be called with mu held.
+func (m *Multiplexer) color(prefix string, w io.Writer) string {
+	if !m.Colors || m.Format != OutputText || os.Getenv("NO_COLOR") != "" || !isTerminal(w) {
+		return ""
+	}
+	color, ok := m.colors[prefix]
+	if !ok {
+		color = nodeColors[len(m.colors)%len(nodeColors)]
+		m.colors[prefix] = color
+	}
+	return color
+}
+
+// LineWriter is a node's writer of a Multiplexer. A carriage return discards the
+// current line, since progress output uses it to rewrite the line, so only the
+// final state of the line is written.
+type LineWriter struct {
+	mux    *Multiplexer
+	prefix string
+	writer io.Writer
+	line   []byte
+	// pendingCR is true if the last byte written was a carriage return, which
+	// ends the line instead of discarding it if it's followed by a newline.
+	pendingCR bool
+}
+
+// Write splits b on newlines, which never occur inside a multi-byte UTF-8
+// sequence, so characters split across writes are reassembled in the buffer.
+func (l *LineWriter) Write(b []byte) (int, error) {
+	l.mux.mu.Lock()
+	defer l.mux.mu.Unlock()
+	for _, c := range b {
+		if l.pendingCR {
+			l.pendingCR = false
+			if c != '\n' {
+				l.line = l.line[:0]
+			}
+		}
+		switch c {
+		case '\n':
+			if err := l.mux.writeLine(l.prefix, l.writer, string(l.line)); err != nil {
+				return 0, err
+			}
+			l.line = l.line[:0]
+		case '\r':
+			l.pendingCR = true
+		default:
+			l.line = append(l.line, c)
+		}
+	}
+	return len(b), nil
+}
+
+// Flush writes the buffered partial line, if any.
+func (l *LineWriter) Flush() error {
+	l.mux.mu.Lock()
+	defer l.mux.mu.Unlock()
+	l.pendingCR = false
+	if len(l.line) == 0 {
+		return nil
+	}
+	err := l.mux.writeLine(l.prefix, l.writer, string(l.line))
+	l.line = l.line[:0]
+	return err
+}
+
+// Flush flushes any of the writers that buffer output, such as the LineWriters
+// of a command, once the command has finished.
+func Flush(writers ...io.Writer) error {
+	var err error
+	for _, w := range writers {
+		if f, ok := w.(interface{ Flush() error }); ok {
+			if e := f.Flush(); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
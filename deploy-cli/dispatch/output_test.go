@@ -0,0 +1,92 @@
+package dispatch
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewMultiplexer().Writer("node", &buf)
+
+	// "é" is split across writes, and the progress line is rewritten before
+	// it's completed with a CRLF split across writes.
+	for _, b := range []string{"caf\xc3", "\xa9\n", "10%\r50%\r", "100%\r", "\nno newline"} {
+		_, err := w.Write([]byte(b))
+		require.NoError(t, err)
+	}
+	require.Equal(t, "[node] café\n[node] 100%\n", buf.String())
+
+	require.NoError(t, Flush(w))
+	require.Equal(t, "[node] café\n[node] 100%\n[node] no newline\n", buf.String())
+}
+
+func TestMultiplexerLineAtomic(t *testing.T) {
+	var buf bytes.Buffer
+	mux := NewMultiplexer()
+
+	var wg sync.WaitGroup
+	for i := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := mux.Writer(fmt.Sprintf("node-%d", i), &buf)
+			for range 100 {
+				// Write each line in pieces so that other nodes get a chance to
+				// write in the middle of it.
+				w.Write([]byte("hello "))
+				w.Write([]byte("world\n"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 500)
+	for _, line := range lines {
+		require.Regexp(t, `^\[node-\d\] hello world$`, line)
+	}
+}
+
+func TestMultiplexerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	mux := NewMultiplexer()
+	mux.Format = OutputJSON
+	mux.events = &buf
+
+	w := mux.Writer("node", &bytes.Buffer{})
+	_, err := w.Write([]byte("\x1B[32mdone\x1B[0m\n"))
+	require.NoError(t, err)
+	slog.New(NewLogHandler(mux, slog.LevelInfo)).Info("finished", "nodes", 1)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	require.Regexp(t, `^\{"time":"[^"]+","type":"output","node":"node","stream":"stdout","line":"done"\}$`, lines[0])
+	require.Regexp(t, `^\{"time":"[^"]+","type":"message","level":"INFO","message":"finished","attrs":\{"nodes":1\}\}$`, lines[1])
+}
+
+func TestMultiplexerQuietLogFile(t *testing.T) {
+	var screen, file bytes.Buffer
+	mux := NewMultiplexer()
+	mux.Quiet = true
+	mux.events = &screen
+	mux.SetLogFile(&file)
+	logger := slog.New(NewLogHandler(mux, slog.LevelWarn))
+
+	w := mux.Writer("node", &screen)
+	_, err := w.Write([]byte("installing vault\n"))
+	require.NoError(t, err)
+	logger.Debug("ran command", "node", "node")
+	logger.Warn("connection lost")
+
+	require.Equal(t, "WARN connection lost\n", screen.String())
+	require.Contains(t, file.String(), `level=INFO msg="installing vault" node=node stream=stdout`)
+	require.Contains(t, file.String(), `level=DEBUG msg="ran command" node=node`)
+	require.Contains(t, file.String(), `level=WARN msg="connection lost"`)
+}
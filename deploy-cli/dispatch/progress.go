@@ -0,0 +1,184 @@
+package dispatch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// ProgressRenderer renders the output of an operation running on several nodes
+// in parallel as one live status line per node, showing the latest line of
+// output from each node. If the output is not a terminal, it falls back to
+// writing each complete line prefixed with the node's name.
+type ProgressRenderer struct {
+	nodes  []string
+	status map[string]string
+	writer io.Writer
+	// live is true if the status lines are redrawn in place.
+	live       bool
+	mu         sync.Mutex
+	hasWritten bool
+}
+
+// This is what multipass writes to the terminal to clear the line
+var multipassClearLine = []byte("\x1B[2K\x1B[0A\x1B[0E")
+
+// NewProgressRenderer creates a ProgressRenderer for the nodes that writes to w.
+// Status lines are only redrawn in place if w is a terminal, the output format
+// is text and node output isn't hidden.
+func NewProgressRenderer(w io.Writer, nodes []Node) *ProgressRenderer {
+	r := &ProgressRenderer{
+		status: make(map[string]string),
+		writer: w,
+		live:   Output.Format == OutputText && !Output.Quiet && isTerminal(w),
+	}
+	for _, node := range nodes {
+		r.nodes = append(r.nodes, node.Name)
+	}
+	return r
+}
+
+// isTerminal returns true if the writer is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// Writer returns a writer for a node's output. Each write updates the node's
+// status line.
+func (r *ProgressRenderer) Writer(node Node) io.Writer {
+	return &progressWriter{renderer: r, node: node.Name}
+}
+
+// SetStatus replaces the status line of a node, e.g. to report that the
+// operation on the node finished.
+func (r *ProgressRenderer) SetStatus(node Node, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.live {
+		return Output.WriteLine(node.Name, r.writer, status)
+	}
+	Output.mu.Lock()
+	defer Output.mu.Unlock()
+	if err := Output.logLine(node.Name, r.writer, ansiEscapePattern.ReplaceAllString(status, "")); err != nil {
+		return err
+	}
+	r.status[node.Name] = status
+	return r.redraw()
+}
+
+// update is called by a node's writer with its latest partial line, and any
+// lines it completed since the last update.
+func (r *ProgressRenderer) update(node string, completed []string, partial string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.live {
+		for _, line := range completed {
+			if err := Output.WriteLine(node, r.writer, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	Output.mu.Lock()
+	defer Output.mu.Unlock()
+	// Only the latest line is shown, but every line is logged.
+	for _, line := range completed {
+		if err := Output.logLine(node, r.writer, ansiEscapePattern.ReplaceAllString(line, "")); err != nil {
+			return err
+		}
+	}
+	switch {
+	case partial != "":
+		r.status[node] = partial
+	case len(completed) > 0:
+		r.status[node] = completed[len(completed)-1]
+	default:
+		return nil
+	}
+	return r.redraw()
+}
+
+// redraw clears the previously drawn status lines and draws them again. Must be
+// called with mu and Output's mu held, so that log records aren't written
+// between the lines.
+func (r *ProgressRenderer) redraw() error {
+	var buf bytes.Buffer
+	if r.hasWritten {
+		for range r.nodes {
+			// Move to the start of the previous line and clear it
+			buf.WriteString("\x1B[1F\x1B[2K")
+		}
+	}
+	width := 0
+	if f, ok := r.writer.(*os.File); ok {
+		width, _, _ = term.GetSize(int(f.Fd()))
+	}
+	for _, node := range r.nodes {
+		line := fmt.Sprintf("[%s] %s", node, r.status[node])
+		// Lines that wrap would throw off the number of lines to clear
+		if runes := []rune(line); width > 0 && len(runes) >= width {
+			line = string(runes[:width-1])
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	r.hasWritten = true
+	_, err := r.writer.Write(buf.Bytes())
+	return err
+}
+
+// progressWriter is the writer for a single node of a ProgressRenderer. It
+// buffers partial lines, and treats carriage returns and multipass's clear line
+// sequence as discarding the current line, since the line is about to be
+// rewritten.
+type progressWriter struct {
+	renderer *ProgressRenderer
+	node     string
+	line     []byte
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	var completed []string
+	data := b
+	for len(data) > 0 {
+		switch {
+		case data[0] == '\n':
+			completed = append(completed, strings.TrimRight(string(p.line), " "))
+			p.line = p.line[:0]
+		case data[0] == '\r':
+			// A CRLF line ending completes the line rather than discarding it
+			if len(data) > 1 && data[1] == '\n' {
+				break
+			}
+			p.line = p.line[:0]
+		default:
+			p.line = append(p.line, data[0])
+			// The sequence may be split across writes, so check the buffered line
+			if bytes.HasSuffix(p.line, multipassClearLine) {
+				p.line = p.line[:0]
+			}
+		}
+		data = data[1:]
+	}
+	if err := p.renderer.update(p.node, completed, string(trimPartialClearLine(p.line))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// trimPartialClearLine trims the start of a multipass clear line sequence from
+// the end of the line so that it isn't drawn before the rest of it arrives.
+func trimPartialClearLine(line []byte) []byte {
+	for n := min(len(line), len(multipassClearLine)-1); n > 0; n-- {
+		if bytes.HasSuffix(line, multipassClearLine[:n]) {
+			return line[:len(line)-n]
+		}
+	}
+	return line
+}
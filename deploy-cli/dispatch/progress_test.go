@@ -0,0 +1,52 @@
+package dispatch
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressRendererPlain(t *testing.T) {
+	var buf bytes.Buffer
+	nodes := []Node{{Name: "master"}, {Name: "worker-1"}}
+	r := NewProgressRenderer(&buf, nodes)
+
+	master := r.Writer(nodes[0])
+	worker := r.Writer(nodes[1])
+	_, err := master.Write([]byte("Downloading 10%\rDownloading 100%\r\nDone"))
+	require.NoError(t, err)
+	_, err = worker.Write([]byte("Starting\x1B[2K"))
+	require.NoError(t, err)
+	_, err = worker.Write([]byte("\x1B[0A\x1B[0EStarted\n"))
+	require.NoError(t, err)
+	_, err = master.Write([]byte("\n"))
+	require.NoError(t, err)
+	require.NoError(t, r.SetStatus(nodes[1], "Finished."))
+
+	require.Equal(
+		t,
+		"[master] Downloading 100%\n[worker-1] Started\n[master] Done\n[worker-1] Finished.\n",
+		buf.String(),
+	)
+}
+
+func TestProgressRendererLiveLogFile(t *testing.T) {
+	var screen, file bytes.Buffer
+	Output.SetLogFile(&file)
+	t.Cleanup(func() { Output.SetLogFile(nil) })
+	nodes := []Node{{Name: "master"}}
+	r := NewProgressRenderer(&screen, nodes)
+	r.live = true
+
+	_, err := r.Writer(nodes[0]).Write([]byte("Downloading\nInstalling\nStarting"))
+	require.NoError(t, err)
+	require.NoError(t, r.SetStatus(nodes[0], "Finished."))
+
+	require.Equal(t, "[master] Starting\n\x1B[1F\x1B[2K[master] Finished.\n", screen.String())
+	// Completed lines are logged even if they were never shown.
+	require.Contains(t, file.String(), `msg=Downloading node=master`)
+	require.Contains(t, file.String(), `msg=Installing node=master`)
+	require.Contains(t, file.String(), `msg=Finished. node=master`)
+	require.NotContains(t, file.String(), "Starting")
+}
@@ -0,0 +1,93 @@
+package dispatch
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/kev-cao/log-console/deploy-cli/transcript"
+)
+
+// Transcript records every command run on a node, if set.
+var Transcript *transcript.Recorder
+
+// RunCommand runs a command on a node with run, which must run it with stdout
+// and stderr as its output. Dispatchers run every command through it so that
+// commands are flushed, logged and recorded to the Transcript the same way.
+func RunCommand(node Node, cmd Command, run func(stdout, stderr io.Writer) error) error {
+	stdout, stderr := cmd.Stdout(), cmd.Stderr()
+	var recordedStdout, recordedStderr bytes.Buffer
+	if Transcript != nil {
+		stdout = io.MultiWriter(stdout, &recordedStdout)
+		stderr = io.MultiWriter(stderr, &recordedStderr)
+	}
+	start := time.Now()
+	err := run(stdout, stderr)
+	Flush(cmd.Stdout(), cmd.Stderr())
+	logCommand(node, cmd, start, err)
+	if Transcript != nil {
+		record := transcript.Command{
+			Node:       node.Name,
+			Command:    cmd.Cmd(),
+			Env:        envKeys(cmd),
+			Started:    start,
+			Duration:   time.Since(start),
+			ExitStatus: ExitStatus(err),
+			Stdout:     recordedStdout.String(),
+			Stderr:     recordedStderr.String(),
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		if recordErr := Transcript.RecordCommand(record); recordErr != nil {
+			slog.Warn("Could not record command to transcript", "err", recordErr)
+		}
+	}
+	return err
+}
+
+// logCommand logs a command that was run on a node at debug level. Only the keys
+// of the command's environment are logged, since values may be secrets.
+func logCommand(node Node, cmd Command, start time.Time, err error) {
+	attrs := []any{
+		"node", node.Name,
+		"command", cmd.Cmd(),
+		"env", envKeys(cmd),
+		"duration", time.Since(start),
+	}
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	}
+	slog.Debug("ran command", attrs...)
+}
+
+func envKeys(cmd Command) []string {
+	keys := make([]string, 0, len(cmd.Env()))
+	for key := range cmd.Env() {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// ExitStatus returns the exit status of a command that failed with err, or -1 if
+// the command did not exit, e.g. because the connection to the node failed.
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	// Remote commands fail with an *ssh.ExitError, and local ones with an
+	// *exec.ExitError.
+	var sshErr interface{ ExitStatus() int }
+	if errors.As(err, &sshErr) {
+		return sshErr.ExitStatus()
+	}
+	var execErr interface{ ExitCode() int }
+	if errors.As(err, &execErr) {
+		return execErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,239 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ScriptDispatcher is a ClusterDispatcher that writes every command it's asked
+// to send to a node into a bash script for that node instead of sending it, so
+// that the scripts can be reviewed and run by hand. The wrapped dispatcher is
+// only used to look up the cluster's nodes, so it doesn't need to be connected.
+type ScriptDispatcher struct {
+	dispatcher ClusterDispatcher
+	dir        string
+	outputs    cannedOutputs
+	captures   []scriptCapture
+	skips      []scriptSkip
+
+	mu      sync.Mutex
+	files   map[string]*os.File
+	section string
+	// sections is the last section written to each node's script.
+	sections map[string]string
+}
+
+// scriptCapture makes the script save the output of commands matching pattern
+// to a shell variable, for output that's used by later commands.
+type scriptCapture struct {
+	pattern  *regexp.Regexp
+	variable string
+	stdout   string
+}
+
+// scriptSkip makes the script leave out commands matching pattern.
+type scriptSkip struct {
+	pattern *regexp.Regexp
+	status  int
+}
+
+// scriptExit is the error of a skipped command that pretends to have exited
+// with a non-zero status.
+type scriptExit int
+
+func (e scriptExit) Error() string {
+	return fmt.Sprintf("exited with status %d", int(e))
+}
+
+func (e scriptExit) ExitStatus() int {
+	return int(e)
+}
+
+var _ ClusterDispatcher = (*ScriptDispatcher)(nil)
+
+// NewScriptDispatcher creates a ScriptDispatcher that writes a script per node
+// of a dispatcher to dir, named after the node.
+func NewScriptDispatcher(d ClusterDispatcher, dir string) (*ScriptDispatcher, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &ScriptDispatcher{
+		dispatcher: d,
+		dir:        dir,
+		files:      make(map[string]*os.File),
+		sections:   make(map[string]string),
+	}, nil
+}
+
+// Script makes commands matching the pattern write stdout, as for
+// DryRunDispatcher.Script. Values only known when the script runs can be
+// written as shell expansions, e.g. ${K3S_TOKEN}.
+func (d *ScriptDispatcher) Script(pattern string, stdout string) {
+	d.outputs = append(d.outputs, cannedOutput{regexp.MustCompile(pattern), stdout})
+}
+
+// Capture makes the script save the output of commands matching the pattern to
+// a shell variable, and print it. The commands write stdout, which may refer to
+// the variable, e.g. ${VAULT_INIT}.
+func (d *ScriptDispatcher) Capture(pattern string, variable string, stdout string) {
+	d.captures = append(d.captures, scriptCapture{regexp.MustCompile(pattern), variable, stdout})
+}
+
+// Skip comments out commands matching the pattern in the script, e.g. checks
+// whose outcome is only known when the script runs. The commands fail with the
+// status, or succeed if it's 0.
+func (d *ScriptDispatcher) Skip(pattern string, status int) {
+	d.skips = append(d.skips, scriptSkip{regexp.MustCompile(pattern), status})
+}
+
+// Section starts a new section of the scripts. The section's title is written
+// before the next command of each script.
+func (d *ScriptDispatcher) Section(title string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.section = title
+}
+
+// Dir returns the directory the scripts are written to.
+func (d *ScriptDispatcher) Dir() string {
+	return d.dir
+}
+
+// Unwrap returns the wrapped dispatcher.
+func (d *ScriptDispatcher) Unwrap() ClusterDispatcher {
+	return d.dispatcher
+}
+
+func (d *ScriptDispatcher) GetNodes() []Node {
+	return d.dispatcher.GetNodes()
+}
+
+func (d *ScriptDispatcher) GetMasterNode() Node {
+	return d.dispatcher.GetMasterNode()
+}
+
+func (d *ScriptDispatcher) GetWorkerNodes() []Node {
+	return d.dispatcher.GetWorkerNodes()
+}
+
+func (d *ScriptDispatcher) Ready() bool {
+	return true
+}
+
+func (d *ScriptDispatcher) Status(_ context.Context) []NodeStatus {
+	var statuses []NodeStatus
+	for _, node := range d.GetNodes() {
+		statuses = append(statuses, NodeStatus{Node: node, Reachable: true, State: "exporting scripts"})
+	}
+	return statuses
+}
+
+func (d *ScriptDispatcher) SendCommands(node Node, cmds ...Command) error {
+	return d.SendCommandsContext(context.Background(), node, cmds...)
+}
+
+func (d *ScriptDispatcher) SendCommandsContext(_ context.Context, node Node, cmds ...Command) error {
+	for _, cmd := range cmds {
+		if err := d.sendCommand(node, cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *ScriptDispatcher) sendCommand(node Node, cmd Command) error {
+	line := cmd.Line()
+	for _, skip := range d.skips {
+		if skip.pattern.MatchString(cmd.Cmd()) {
+			if err := d.write(node, "# "+strings.ReplaceAll(line, "\n", "\n# ")); err != nil {
+				return err
+			}
+			if skip.status != 0 {
+				return scriptExit(skip.status)
+			}
+			return nil
+		}
+	}
+	defer Flush(cmd.Stdout(), cmd.Stderr())
+	for _, capture := range d.captures {
+		if capture.pattern.MatchString(cmd.Cmd()) {
+			if err := d.write(node, fmt.Sprintf(
+				"%[1]s=\"$(%[2]s)\"\nprintf '%%s\\n' \"${%[1]s}\"", capture.variable, line,
+			)); err != nil {
+				return err
+			}
+			_, err := io.WriteString(cmd.Stdout(), capture.stdout)
+			return err
+		}
+	}
+	if err := d.write(node, line); err != nil {
+		return err
+	}
+	return d.outputs.write(cmd)
+}
+
+func (d *ScriptDispatcher) SendFile(node Node, src, dst string) error {
+	return d.write(node, fmt.Sprintf(
+		"# deploy-cli copies %s from the deploying machine to %s here.", src, dst,
+	))
+}
+
+func (d *ScriptDispatcher) DownloadProject(node Node, source string) error {
+	return d.write(node, fmt.Sprintf(
+		"# deploy-cli downloads the project from %s here.", source,
+	))
+}
+
+// Cleanup closes the scripts and cleans up the wrapped dispatcher.
+func (d *ScriptDispatcher) Cleanup() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name, f := range d.files {
+		f.Close()
+		delete(d.files, name)
+	}
+	return d.dispatcher.Cleanup()
+}
+
+// write appends a line to a node's script, creating the script if it's the
+// first line for the node.
+func (d *ScriptDispatcher) write(node Node, line string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, ok := d.files[node.Name]
+	if !ok {
+		var err error
+		f, err = os.OpenFile(
+			filepath.Join(d.dir, node.Name+".sh"),
+			os.O_CREATE|os.O_TRUNC|os.O_WRONLY,
+			0755,
+		)
+		if err != nil {
+			return err
+		}
+		d.files[node.Name] = f
+		if _, err := fmt.Fprintf(
+			f,
+			"#!/usr/bin/env bash\n"+
+				"# Generated by deploy-cli for node %s (%s).\n"+
+				"# Runs the commands deploy-cli would send to the node, in order. Values deploy-cli\n"+
+				"# reads from other nodes are left as shell variables to set in the environment.\n"+
+				"set -euo pipefail\n",
+			node.Name, node.Kubename,
+		); err != nil {
+			return err
+		}
+	}
+	if d.sections[node.Name] != d.section {
+		d.sections[node.Name] = d.section
+		line = fmt.Sprintf("\n# %s\n%s", d.section, line)
+	}
+	_, err := fmt.Fprintln(f, line)
+	return err
+}
@@ -0,0 +1,42 @@
+package dispatch
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptDispatcher(t *testing.T) {
+	dir := t.TempDir()
+	node := Node{Name: "master", Kubename: "master"}
+	d, err := NewScriptDispatcher(fakeDispatcher{nodes: []Node{node}}, dir)
+	require.NoError(t, err)
+	d.Capture(`tls-ca`, "TLS_CA", "${TLS_CA}\n")
+	d.Skip(`jq --version`, 127)
+
+	d.Section("Installing")
+	require.Equal(t, 127, ExitStatus(d.SendCommands(node, NewCommand("jq --version"))))
+	require.NoError(t, d.SendCommands(
+		node,
+		NewCommand("sudo apt-get install -y jq", WithEnv(map[string]string{"DEBIAN_FRONTEND": "noninteractive"})),
+	))
+	d.Section("Certificates")
+	var ca bytes.Buffer
+	require.NoError(t, d.SendCommands(node, NewCommand("kubectl get secrets tls-ca", WithStdout(&ca))))
+	require.Equal(t, "${TLS_CA}\n", ca.String())
+	require.NoError(t, d.Cleanup())
+
+	script, err := os.ReadFile(filepath.Join(dir, "master.sh"))
+	require.NoError(t, err)
+	require.Contains(t, string(script), "set -euo pipefail\n"+
+		"\n# Installing\n"+
+		"# jq --version\n"+
+		"DEBIAN_FRONTEND=noninteractive sudo apt-get install -y jq\n"+
+		"\n# Certificates\n"+
+		"TLS_CA=\"$(kubectl get secrets tls-ca)\"\n"+
+		"printf '%s\\n' \"${TLS_CA}\"\n",
+	)
+}
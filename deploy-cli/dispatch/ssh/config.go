@@ -0,0 +1,133 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kev-cao/log-console/utils/pathutils"
+)
+
+// sshConfig is a minimal parser for OpenSSH client configuration files
+// (~/.ssh/config). Only the subset of options used by the dispatcher is
+// understood; everything else is parsed but ignored.
+type sshConfig struct {
+	hosts []hostBlock
+}
+
+// hostBlock is a single `Host` section of an SSH config file.
+type hostBlock struct {
+	patterns []string
+	options  map[string][]string
+}
+
+// loadSshConfig reads the SSH config file at the given path. A missing file is
+// not an error and results in an empty config.
+func loadSshConfig(file string) (*sshConfig, error) {
+	configPath, err := pathutils.AbsolutePath(file)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return &sshConfig{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseSshConfig(f)
+}
+
+// parseSshConfig parses an SSH config from a reader.
+func parseSshConfig(r io.Reader) (*sshConfig, error) {
+	config := &sshConfig{}
+	// Options before the first Host line apply to every host.
+	current := hostBlock{patterns: []string{"*"}, options: make(map[string][]string)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := splitConfigLine(line)
+		if !ok {
+			continue
+		}
+		switch key {
+		case "host":
+			config.hosts = append(config.hosts, current)
+			current = hostBlock{patterns: strings.Fields(value), options: make(map[string][]string)}
+		case "match":
+			// Match blocks are not supported, so their options are dropped by
+			// giving the block a pattern that never matches.
+			config.hosts = append(config.hosts, current)
+			current = hostBlock{options: make(map[string][]string)}
+		default:
+			current.options[key] = append(current.options[key], value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	config.hosts = append(config.hosts, current)
+	return config, nil
+}
+
+// splitConfigLine splits a config line into its lowercased keyword and its value.
+// Both `Key Value` and `Key=Value` forms are accepted.
+func splitConfigLine(line string) (key string, value string, ok bool) {
+	idx := strings.IndexAny(line, " \t=")
+	if idx == -1 {
+		return "", "", false
+	}
+	key = strings.ToLower(line[:idx])
+	value = strings.TrimSpace(line[idx:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	value = strings.Trim(value, `"`)
+	return key, value, value != ""
+}
+
+// Get returns the value of an option for a host alias. As with OpenSSH, the
+// first obtained value wins.
+func (c *sshConfig) Get(alias, key string) string {
+	values := c.GetAll(alias, key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// GetAll returns every value of an option for a host alias in the order they
+// appear in the config. Used for options that may be specified multiple times,
+// such as IdentityFile.
+func (c *sshConfig) GetAll(alias, key string) []string {
+	key = strings.ToLower(key)
+	var values []string
+	for _, host := range c.hosts {
+		if host.matches(alias) {
+			values = append(values, host.options[key]...)
+		}
+	}
+	return values
+}
+
+// matches returns true if the alias matches one of the block's patterns and
+// none of its negated patterns.
+func (h hostBlock) matches(alias string) bool {
+	matched := false
+	for _, pattern := range h.patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if ok, _ := path.Match(negated, alias); ok {
+				return false
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, alias); ok {
+			matched = true
+		}
+	}
+	return matched
+}
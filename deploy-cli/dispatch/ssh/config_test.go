@@ -0,0 +1,190 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSshConfig(t *testing.T) {
+	config, err := parseSshConfig(strings.NewReader(`
+# Global options
+User global
+
+Host pi-master
+	HostName 192.168.1.10
+	Port 2222
+	IdentityFile ~/.ssh/pi_ed25519
+
+Host pi-* !pi-bastion
+	User pi
+	IdentityFile=~/.ssh/pi_rsa
+
+Host *
+	User fallback
+	IdentityFile ~/.ssh/id_ed25519
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		alias         string
+		hostname      string
+		user          string
+		port          string
+		identityFiles []string
+	}{
+		{
+			name:          "exact match takes precedence over wildcard",
+			alias:         "pi-master",
+			hostname:      "192.168.1.10",
+			user:          "global",
+			port:          "2222",
+			identityFiles: []string{"~/.ssh/pi_ed25519", "~/.ssh/pi_rsa", "~/.ssh/id_ed25519"},
+		},
+		{
+			name:          "wildcard match",
+			alias:         "pi-worker-1",
+			user:          "global",
+			identityFiles: []string{"~/.ssh/pi_rsa", "~/.ssh/id_ed25519"},
+		},
+		{
+			name:          "negated pattern is excluded",
+			alias:         "pi-bastion",
+			user:          "global",
+			identityFiles: []string{"~/.ssh/id_ed25519"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.hostname, config.Get(test.alias, "HostName"))
+			require.Equal(t, test.user, config.Get(test.alias, "User"))
+			require.Equal(t, test.port, config.Get(test.alias, "Port"))
+			require.Equal(t, test.identityFiles, config.GetAll(test.alias, "IdentityFile"))
+		})
+	}
+}
+
+func TestSshDispatcherNodes(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(configFile, []byte("Host pi-master\n\tHostName 192.168.1.10\n"), 0600))
+	s := &SshDispatcher{
+		Remotes: []dispatch.UserQualifiedHostname{
+			{FQDN: "pi-master"},
+			{FQDN: "pi-worker", Port: "2222"},
+		},
+		SshConfigFile: configFile,
+	}
+	// Aliases are resolved without connecting.
+	require.Equal(t, "192.168.1.10", s.GetMasterNode().Address())
+	worker := s.GetWorkerNodes()[0]
+	require.Equal(t, "pi-worker", worker.Address())
+	require.Equal(t, "worker-1", worker.Kubename)
+	require.Equal(t, "2222", s.resolveHost(worker.Remote).port)
+}
+
+// jumpConfig is an SSH config with a bastion alias and nodes that override the
+// cluster-wide jump hosts.
+const jumpConfig = `
+Host bastion
+	HostName bastion.example.com
+	User admin
+	Port 2222
+
+Host pi-master
+	ProxyJump bastion, ops@gw.example.com:2200
+
+Host pi-worker-1
+	ProxyJump none
+
+Host pi-broken
+	ProxyJump bastion,@:22
+`
+
+func newJumpTestDispatcher(t *testing.T) *SshDispatcher {
+	t.Setenv("USER", "me")
+	config, err := parseSshConfig(strings.NewReader(jumpConfig))
+	require.NoError(t, err)
+	return &SshDispatcher{
+		JumpHosts: []dispatch.UserQualifiedHostname{{User: "ci", FQDN: "jump.example.com"}},
+		sshConfig: config,
+	}
+}
+
+func TestParseJumpSpec(t *testing.T) {
+	s := newJumpTestDispatcher(t)
+	tests := []struct {
+		spec     string
+		expected resolvedHost
+		err      string
+	}{
+		{spec: "bastion", expected: resolvedHost{user: "admin", hostname: "bastion.example.com", port: "2222"}},
+		{spec: "ops@bastion", expected: resolvedHost{user: "ops", hostname: "bastion.example.com", port: "2222"}},
+		{spec: "bastion:2200", expected: resolvedHost{user: "admin", hostname: "bastion.example.com", port: "2200"}},
+		{spec: "gw.example.com", expected: resolvedHost{user: "me", hostname: "gw.example.com", port: "22"}},
+		{spec: "ops@gw.example.com:2200", expected: resolvedHost{user: "ops", hostname: "gw.example.com", port: "2200"}},
+		{spec: "ops@[::1]:2200", expected: resolvedHost{user: "ops", hostname: "::1", port: "2200"}},
+		{spec: "ops@", err: "invalid jump host: ops@"},
+		{spec: ":22", err: "invalid jump host: :22"},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			host, err := s.parseJumpSpec(test.spec)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, host)
+		})
+	}
+}
+
+func TestJumpHostsFor(t *testing.T) {
+	s := newJumpTestDispatcher(t)
+	tests := []struct {
+		name     string
+		remote   string
+		expected []resolvedHost
+		err      string
+	}{
+		{
+			name:   "ProxyJump chain overrides the jump hosts",
+			remote: "pi-master",
+			expected: []resolvedHost{
+				{user: "admin", hostname: "bastion.example.com", port: "2222"},
+				{user: "ops", hostname: "gw.example.com", port: "2200"},
+			},
+		},
+		{
+			name:   "ProxyJump none connects directly",
+			remote: "pi-worker-1",
+		},
+		{
+			name:     "jump hosts apply to the other nodes",
+			remote:   "pi-worker-2",
+			expected: []resolvedHost{{user: "ci", hostname: "jump.example.com", port: "22"}},
+		},
+		{
+			name:   "invalid ProxyJump hop",
+			remote: "pi-broken",
+			err:    "invalid jump host: @:22",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := s.jumpHostsFor(dispatch.UserQualifiedHostname{FQDN: test.remote})
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, hosts)
+		})
+	}
+}
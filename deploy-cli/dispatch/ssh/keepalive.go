@@ -0,0 +1,54 @@
+package ssh
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultKeepaliveInterval is how often keepalive requests are sent when the
+// dispatcher does not specify an interval.
+const defaultKeepaliveInterval = 15 * time.Second
+
+// keepalive periodically sends keepalive requests on the client until the
+// connection is closed. If the remote fails to respond within the interval, the
+// client is closed so that the dispatcher redials it on next use instead of
+// hanging on a dead connection.
+func keepalive(client *ssh.Client, interval time.Duration) {
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			if !isAlive(client, interval) {
+				client.Close()
+				return
+			}
+		}
+	}
+}
+
+// isAlive sends a single keepalive request and reports whether the remote
+// replied within the timeout.
+func isAlive(client *ssh.Client, timeout time.Duration) bool {
+	replied := make(chan error, 1)
+	go func() {
+		// OpenSSH servers reply with a failure to unknown requests, which still
+		// proves the connection is alive, so only the transport error matters.
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		replied <- err
+	}()
+	select {
+	case err := <-replied:
+		return err == nil
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,47 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeepalive(t *testing.T) {
+	server := serveSSH(t)
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	client, err := ssh.Dial("tcp", net.JoinHostPort("127.0.0.1", server.port), &ssh.ClientConfig{
+		User:            "pi",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+	defer client.Close()
+	require.True(t, isAlive(client, time.Second))
+
+	done := make(chan struct{})
+	go func() {
+		keepalive(client, 50*time.Millisecond)
+		close(done)
+	}()
+	// Keepalives don't close live connections.
+	time.Sleep(200 * time.Millisecond)
+	require.True(t, isAlive(client, time.Second))
+
+	server.hang()
+	require.False(t, isAlive(client, 50*time.Millisecond))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("keepalive did not close the hung connection")
+	}
+	// The connection is closed so that it's redialed.
+	_, err = client.NewSession()
+	require.Error(t, err)
+}
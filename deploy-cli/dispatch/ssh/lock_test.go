@@ -0,0 +1,33 @@
+package ssh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	var k keyedMutex
+	unlock := k.Lock("pi-master")
+	// Other keys aren't blocked.
+	k.Lock("pi-worker")()
+
+	locked := make(chan struct{})
+	go func() {
+		k.Lock("pi-master")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("locked a key that was already locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("key was not unlocked")
+	}
+	require.Len(t, k.locks, 2)
+}
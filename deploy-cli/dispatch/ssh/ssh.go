@@ -6,27 +6,77 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kev-cao/log-console/deploy-cli/dispatch"
 	"github.com/kev-cao/log-console/utils/pathutils"
 	"github.com/kev-cao/log-console/utils/sliceutils"
-	"github.com/kev-cao/log-console/utils/stringutils"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/term"
 )
 
 type SshDispatcher struct {
 	NumNodes int
-	// Remotes to SSH to. First address is the master node.
-	Remotes        []dispatch.UserQualifiedHostname
+	// Remotes to SSH to. First address is the master node. Hostnames may be
+	// aliases defined in the SSH config file.
+	Remotes []dispatch.UserQualifiedHostname
+	// PrivateKeyFile is an optional private key to authenticate with. Keys held by
+	// the SSH agent and identity files from the SSH config are always tried.
 	PrivateKeyFile string
-	connections    map[string]*ssh.Client
+	// SshConfigFile is the OpenSSH client config used to resolve remote aliases.
+	// Defaults to ~/.ssh/config.
+	SshConfigFile string
+	// JumpHosts are bastions to tunnel through for every node in the cluster, in
+	// the order they are hopped through. A ProxyJump set for a node in the SSH
+	// config takes precedence.
+	JumpHosts []dispatch.UserQualifiedHostname
+	// KeepaliveInterval is how often keepalive requests are sent on each
+	// connection. Defaults to 15 seconds.
+	KeepaliveInterval time.Duration
+	// DialTimeout is how long to wait for each remote to connect. Defaults to
+	// 10 seconds.
+	DialTimeout time.Duration
+	// AllowUnreachable lets the dispatcher be created when only some remotes can
+	// be connected to. Commands sent to an unreachable node retry the connection
+	// and fail if the node is still unreachable.
+	AllowUnreachable bool
+	// mu guards connections, which are replaced when a dropped connection is
+	// redialed. It's never held across network I/O.
+	mu          sync.Mutex
+	connections map[string]*ssh.Client
+	// redials serializes redialing each node, so that sessions started on a node
+	// while its connection is down share one redial.
+	redials keyedMutex
+	// jumpMu guards jumpClients and jumpFailures. It's never held across network
+	// I/O.
+	jumpMu sync.Mutex
+	// jumpClients caches connections to jump hosts by their hop chain so that
+	// nodes behind the same bastion share a connection.
+	jumpClients map[string]*ssh.Client
+	// jumpFailures is the last failed dial of each hop chain.
+	jumpFailures map[string]jumpFailure
+	// jumpDials serializes dialing each hop chain.
+	jumpDials      keyedMutex
 	privateKeyPass string
+	// sshConfig is loaded by init, or on first use by dispatchers that aren't
+	// connected, e.g. for dry runs.
+	sshConfig  *sshConfig
+	configOnce sync.Once
+	// signersMu guards signers and serializes passphrase prompts.
+	signersMu sync.Mutex
+	// signers caches parsed private keys by their absolute path so that each
+	// passphrase is only prompted once.
+	signers   map[string]ssh.Signer
+	agentConn net.Conn
+	agent     agent.ExtendedAgent
 }
 
 type outputPipes struct {
@@ -36,12 +86,19 @@ type outputPipes struct {
 
 var _ dispatch.ClusterDispatcher = &SshDispatcher{}
 
-func NewSshDispatcher(remotes []dispatch.UserQualifiedHostname, privateKeyFile string) (*SshDispatcher, error) {
+func NewSshDispatcher(
+	remotes []dispatch.UserQualifiedHostname,
+	privateKeyFile string,
+	jumpHosts []dispatch.UserQualifiedHostname,
+	allowUnreachable bool,
+) (*SshDispatcher, error) {
 	dispatcher := &SshDispatcher{
-		NumNodes:       len(remotes),
-		Remotes:        remotes,
-		PrivateKeyFile: privateKeyFile,
-		connections:    make(map[string]*ssh.Client),
+		NumNodes:         len(remotes),
+		Remotes:          remotes,
+		PrivateKeyFile:   privateKeyFile,
+		JumpHosts:        jumpHosts,
+		AllowUnreachable: allowUnreachable,
+		connections:      make(map[string]*ssh.Client),
 	}
 	if err := dispatcher.init(); err != nil {
 		return nil, err
@@ -49,54 +106,455 @@ func NewSshDispatcher(remotes []dispatch.UserQualifiedHostname, privateKeyFile s
 	return dispatcher, nil
 }
 
-// init initializes the dispatcher by connecting to all the remotes and instantiating
-// a session for each.
+// init initializes the dispatcher by connecting to all the remotes in parallel.
+// Every connection failure is reported, not just the first.
 func (s *SshDispatcher) init() error {
-	signer, err := s.getPrivateKeySigner()
+	config, err := loadSshConfig(s.sshConfigFile())
 	if err != nil {
+		return fmt.Errorf("failed to read ssh config: %w", err)
+	}
+	s.sshConfig = config
+	if s.KeepaliveInterval <= 0 {
+		s.KeepaliveInterval = defaultKeepaliveInterval
+	}
+	if s.DialTimeout <= 0 {
+		s.DialTimeout = defaultDialTimeout
+	}
+	s.signers = make(map[string]ssh.Signer)
+	s.connectAgent()
+	if err := s.loadCredentials(); err != nil {
 		return err
 	}
 
 	s.connections = make(map[string]*ssh.Client)
+	s.jumpClients = make(map[string]*ssh.Client)
+	s.jumpFailures = make(map[string]jumpFailure)
+	errs := make([]error, len(s.Remotes))
+	var wg sync.WaitGroup
+	for idx, remote := range s.Remotes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Connect to the address and add it to the connection pool
+			client, err := s.dial(remote)
+			if err != nil {
+				errs[idx] = fmt.Errorf("failed to connect to %s: %w", remote, err)
+				return
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.connections[remote.FQDN] = client
+		}()
+	}
+	wg.Wait()
+
+	err = errors.Join(errs...)
+	if err == nil {
+		return nil
+	}
+	if !s.AllowUnreachable || len(s.connections) == 0 {
+		s.Cleanup()
+		return err
+	}
+	slog.Warn(
+		fmt.Sprintf("Continuing with %d of %d nodes reachable", len(s.connections), s.NumNodes),
+		"err", err,
+	)
+	return nil
+}
+
+// defaultDialTimeout is how long to wait for a remote to connect when the
+// dispatcher does not specify a timeout.
+const defaultDialTimeout = 10 * time.Second
+
+// loadCredentials loads the credentials for every remote and jump host up front
+// so that passphrase prompts happen before dialing starts, and don't count
+// against the dial timeout.
+func (s *SshDispatcher) loadCredentials() error {
+	hosts := sliceutils.Map(s.Remotes, func(remote dispatch.UserQualifiedHostname, _ int) resolvedHost {
+		return s.resolveHost(remote)
+	})
 	for _, remote := range s.Remotes {
-		// Connect to the address and add it to the connection pool
-		config := &ssh.ClientConfig{
-			User: remote.User,
-			Auth: []ssh.AuthMethod{
-				ssh.PublicKeys(signer),
-			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		jumpHosts, err := s.jumpHostsFor(remote)
+		if err != nil {
+			return fmt.Errorf("failed to resolve jump hosts for %s: %w", remote, err)
 		}
+		hosts = append(hosts, jumpHosts...)
+	}
+	for _, host := range hosts {
+		if _, err := s.authMethod(host); err != nil {
+			return fmt.Errorf("failed to load credentials for %s: %w", host.hostname, err)
+		}
+	}
+	return nil
+}
+
+// dial connects to a remote, tunnelling through its jump hosts if it has any.
+// Each hop is given the dial timeout from when its own dial starts.
+func (s *SshDispatcher) dial(remote dispatch.UserQualifiedHostname) (*ssh.Client, error) {
+	host := s.resolveHost(remote)
+	jumpHosts, err := s.jumpHostsFor(remote)
+	if err != nil {
+		return nil, err
+	}
+	var bastion *ssh.Client
+	chain := ""
+	for _, jumpHost := range jumpHosts {
+		chain += jumpHost.addr() + ","
+		if bastion, err = s.jumpClient(chain, bastion, jumpHost); err != nil {
+			return nil, fmt.Errorf("failed to connect to jump host %s: %w", jumpHost.addr(), err)
+		}
+	}
+	return s.dialWithTimeout(bastion, host)
+}
+
+// jumpFailure is a failed dial of a jump host.
+type jumpFailure struct {
+	at  time.Time
+	err error
+}
+
+// jumpClient returns a connection to the last jump host of a hop chain, dialing
+// it through bastion unless a live one is cached. Nodes behind the same chain
+// wait for a single dial instead of racing to open separate connections, and
+// share its error if it fails.
+func (s *SshDispatcher) jumpClient(chain string, bastion *ssh.Client, jumpHost resolvedHost) (*ssh.Client, error) {
+	waiting := time.Now()
+	unlock := s.jumpDials.Lock(chain)
+	defer unlock()
+	s.jumpMu.Lock()
+	client, ok := s.jumpClients[chain]
+	failure, failed := s.jumpFailures[chain]
+	s.jumpMu.Unlock()
+	if failed && failure.at.After(waiting) {
+		return nil, failure.err
+	}
+	if ok && isAlive(client, s.KeepaliveInterval) {
+		return client, nil
+	} else if ok {
+		client.Close()
+	}
+	client, err := s.dialWithTimeout(bastion, jumpHost)
+	s.jumpMu.Lock()
+	defer s.jumpMu.Unlock()
+	if err != nil {
+		delete(s.jumpClients, chain)
+		s.jumpFailures[chain] = jumpFailure{time.Now(), err}
+		return nil, err
+	}
+	delete(s.jumpFailures, chain)
+	s.jumpClients[chain] = client
+	return client, nil
+}
+
+// dialWithTimeout dials the host, giving up after the dial timeout. A
+// connection that completes after the timeout is closed.
+func (s *SshDispatcher) dialWithTimeout(bastion *ssh.Client, host resolvedHost) (*ssh.Client, error) {
+	type dialResult struct {
+		client *ssh.Client
+		err    error
+	}
+	done := make(chan dialResult, 1)
+	go func() {
+		client, err := s.dialHost(bastion, host)
+		done <- dialResult{client, err}
+	}()
+	select {
+	case res := <-done:
+		return res.client, res.err
+	case <-time.After(s.DialTimeout):
+		go func() {
+			if res := <-done; res.client != nil {
+				res.client.Close()
+			}
+		}()
+		return nil, fmt.Errorf("timed out after %s", s.DialTimeout)
+	}
+}
 
-		// Will always assume port 22 for SSH for simplicity
-		client, err := ssh.Dial("tcp", remote.FQDN+":22", config)
+// dialHost opens an SSH connection to the host. If bastion is not nil, the TCP
+// connection is tunnelled through it.
+func (s *SshDispatcher) dialHost(bastion *ssh.Client, host resolvedHost) (*ssh.Client, error) {
+	auth, err := s.authMethod(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credentials: %w", err)
+	}
+	config := &ssh.ClientConfig{
+		User:            host.user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         s.DialTimeout,
+	}
+	var client *ssh.Client
+	if bastion == nil {
+		if client, err = ssh.Dial("tcp", host.addr(), config); err != nil {
+			return nil, err
+		}
+	} else {
+		conn, err := bastion.Dial("tcp", host.addr())
+		if err != nil {
+			return nil, err
+		}
+		clientConn, chans, reqs, err := ssh.NewClientConn(conn, host.addr(), config)
 		if err != nil {
-			return fmt.Errorf("failed to connect to %s: %v", remote, err)
+			conn.Close()
+			return nil, err
 		}
-		s.connections[remote.FQDN] = client
+		client = ssh.NewClient(clientConn, chans, reqs)
 	}
-	return nil
+	go keepalive(client, s.KeepaliveInterval)
+	return client, nil
+}
+
+// newSession creates a session on the node's connection. If the connection has
+// dropped, it is redialed once before giving up.
+func (s *SshDispatcher) newSession(node dispatch.Node) (*ssh.Session, error) {
+	client, ok := s.connection(node)
+	if ok {
+		session, err := client.NewSession()
+		if err == nil || !s.lost(client, err) {
+			return session, err
+		}
+	} else if !s.AllowUnreachable {
+		return nil, errors.New("no connection found for node " + node.Name)
+	}
+
+	unlock := s.redials.Lock(node.Name)
+	defer unlock()
+	// Another session may have redialed the node while this one waited.
+	if current, ok := s.connection(node); ok && current != client {
+		session, err := current.NewSession()
+		if err == nil || !s.lost(current, err) {
+			return session, err
+		}
+		client = current
+	}
+	if client != nil {
+		slog.Warn("Connection lost, reconnecting...", "node", node.Name)
+		client.Close()
+	}
+	client, err := s.dial(node.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect to %s: %w", node.Name, err)
+	}
+	s.mu.Lock()
+	s.connections[node.Name] = client
+	s.mu.Unlock()
+	return client.NewSession()
+}
+
+// lost reports whether a connection that failed to open a session with err has
+// dropped. The remote can reject a session on a live connection, e.g. when it
+// has too many open, and the connection is left to the other sessions on it.
+func (s *SshDispatcher) lost(client *ssh.Client, err error) bool {
+	var rejected *ssh.OpenChannelError
+	return !errors.As(err, &rejected) || !isAlive(client, s.KeepaliveInterval)
+}
+
+// connection returns the current connection to a node, if any.
+func (s *SshDispatcher) connection(node dispatch.Node) (*ssh.Client, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.connections[node.Name]
+	return client, ok
+}
+
+// jumpHostsFor returns the resolved jump hosts to hop through to reach the
+// remote. A ProxyJump in the SSH config overrides the cluster-wide jump hosts,
+// and `ProxyJump none` disables jumping for the remote.
+func (s *SshDispatcher) jumpHostsFor(remote dispatch.UserQualifiedHostname) ([]resolvedHost, error) {
+	if proxyJump := s.config().Get(remote.FQDN, "ProxyJump"); proxyJump != "" {
+		if proxyJump == "none" {
+			return nil, nil
+		}
+		return sliceutils.MapErr(
+			strings.Split(proxyJump, ","),
+			func(spec string, _ int) (resolvedHost, error) {
+				return s.parseJumpSpec(strings.TrimSpace(spec))
+			},
+		)
+	}
+	return sliceutils.Map(s.JumpHosts, func(jumpHost dispatch.UserQualifiedHostname, _ int) resolvedHost {
+		return s.resolveHost(jumpHost)
+	}), nil
+}
+
+// parseJumpSpec parses a ProxyJump hop in the form [user@]host[:port] and
+// resolves it against the SSH config.
+func (s *SshDispatcher) parseJumpSpec(spec string) (resolvedHost, error) {
+	hostPort := spec
+	var user string
+	if idx := strings.LastIndex(spec, "@"); idx != -1 {
+		user, hostPort = spec[:idx], spec[idx+1:]
+	}
+	hostname, port := hostPort, ""
+	if h, p, err := net.SplitHostPort(hostPort); err == nil {
+		hostname, port = h, p
+	}
+	if hostname == "" {
+		return resolvedHost{}, fmt.Errorf("invalid jump host: %s", spec)
+	}
+	host := s.resolveHost(dispatch.UserQualifiedHostname{User: user, FQDN: hostname})
+	if port != "" {
+		host.port = port
+	}
+	return host, nil
+}
+
+func (s *SshDispatcher) sshConfigFile() string {
+	if s.SshConfigFile == "" {
+		s.SshConfigFile = filepath.Join("~", ".ssh", "config")
+	}
+	return s.SshConfigFile
+}
+
+// config returns the SSH config, loading it on first use if init didn't.
+func (s *SshDispatcher) config() *sshConfig {
+	s.configOnce.Do(func() {
+		if s.sshConfig != nil {
+			return
+		}
+		config, err := loadSshConfig(s.sshConfigFile())
+		if err != nil {
+			slog.Warn("Could not read SSH config, ignoring it", "err", err)
+			config = &sshConfig{}
+		}
+		s.sshConfig = config
+	})
+	return s.sshConfig
+}
+
+// resolvedHost is the connection information for a remote after applying the
+// SSH config.
+type resolvedHost struct {
+	user          string
+	hostname      string
+	port          string
+	identityFiles []string
+}
+
+func (h resolvedHost) addr() string {
+	return net.JoinHostPort(h.hostname, h.port)
+}
+
+// resolveHost resolves a remote against the SSH config. Values given explicitly
+// on the remote take precedence over the config, and the config takes precedence
+// over the defaults (current user, port 22).
+func (s *SshDispatcher) resolveHost(remote dispatch.UserQualifiedHostname) resolvedHost {
+	host := resolvedHost{
+		user:     remote.User,
+		hostname: remote.FQDN,
+		port:     "22",
+	}
+	if host.user == "" {
+		host.user = s.config().Get(remote.FQDN, "User")
+	}
+	if host.user == "" {
+		host.user = os.Getenv("USER")
+	}
+	if hostname := s.config().Get(remote.FQDN, "HostName"); hostname != "" {
+		// %h is the only token commonly used in HostName
+		host.hostname = strings.ReplaceAll(hostname, "%h", remote.FQDN)
+	}
+	if remote.Port != "" {
+		host.port = remote.Port
+	} else if port := s.config().Get(remote.FQDN, "Port"); port != "" {
+		host.port = port
+	}
+	host.identityFiles = s.config().GetAll(remote.FQDN, "IdentityFile")
+	return host
 }
 
-func (s *SshDispatcher) getPrivateKeySigner() (ssh.Signer, error) {
-	if s.PrivateKeyFile == "" {
-		s.PrivateKeyFile = filepath.Join("~", ".ssh", "id_rsa")
+// connectAgent connects to the SSH agent listening on SSH_AUTH_SOCK, if any.
+// Failing to connect is not an error as the agent is optional.
+func (s *SshDispatcher) connectAgent() {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return
 	}
-	keyFile, err := pathutils.AbsolutePath(s.PrivateKeyFile)
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		slog.Warn("Could not connect to SSH agent, ignoring it", "err", err)
+		return
+	}
+	s.agentConn = conn
+	s.agent = agent.NewClient(conn)
+}
+
+// authMethod builds the public key auth method for a host. The agent's keys are
+// offered first, followed by the explicit private key file and then the identity
+// files from the SSH config (or ~/.ssh/id_rsa if there are none).
+func (s *SshDispatcher) authMethod(host resolvedHost) (ssh.AuthMethod, error) {
+	var signers []ssh.Signer
+	if s.agent != nil {
+		agentSigners, err := s.agent.Signers()
+		if err != nil {
+			slog.Warn("Could not list SSH agent keys, ignoring agent", "err", err)
+		}
+		signers = append(signers, agentSigners...)
+	}
+	hasAgentKeys := len(signers) > 0
+
+	if s.PrivateKeyFile != "" {
+		signer, err := s.getPrivateKeySigner(s.PrivateKeyFile, true)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	keyFiles := host.identityFiles
+	if len(keyFiles) == 0 {
+		keyFiles = []string{filepath.Join("~", ".ssh", "id_rsa")}
+	}
+	for _, keyFile := range keyFiles {
+		// Only prompt for passphrases of implicit keys if the agent can't help.
+		signer, err := s.getPrivateKeySigner(keyFile, !hasAgentKeys)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, errPassphraseSkipped) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		return nil, errors.New("no SSH agent keys or private key files available")
+	}
+	return ssh.PublicKeys(signers...), nil
+}
+
+// errPassphraseSkipped is returned when a private key requires a passphrase but
+// prompting was not allowed.
+var errPassphraseSkipped = errors.New("private key requires a passphrase")
+
+// getPrivateKeySigner parses the private key at the given path, prompting for its
+// passphrase if needed and allowed.
+func (s *SshDispatcher) getPrivateKeySigner(file string, promptPassphrase bool) (ssh.Signer, error) {
+	s.signersMu.Lock()
+	defer s.signersMu.Unlock()
+	keyFile, err := pathutils.AbsolutePath(file)
 	if err != nil {
 		return nil, errors.New("failed to resolve private key path: " + err.Error())
 	}
+	if signer, ok := s.signers[keyFile]; ok {
+		return signer, nil
+	}
 	key, err := os.ReadFile(keyFile)
 	if err != nil {
-		return nil, errors.New("failed to read private key file: " + err.Error())
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
 	}
 	signer, err := ssh.ParsePrivateKey(key)
 	if err == nil {
+		s.signers[keyFile] = signer
 		return signer, err
 	}
 	// crypto/ssh does not provide an `Is` method for PassphraseMissingError so
 	// resorting to this.
 	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		if !promptPassphrase {
+			return nil, errPassphraseSkipped
+		}
 		fmt.Printf("Enter passphrase for %s (hidden for security): ", keyFile)
 		passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
 		fmt.Println()
@@ -104,13 +562,20 @@ func (s *SshDispatcher) getPrivateKeySigner() (ssh.Signer, error) {
 			return nil, errors.New("failed to read passphrase: " + err.Error())
 		}
 		s.privateKeyPass = string(passphrase)
-		return ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+		if err != nil {
+			return nil, errors.New("failed to parse private key: " + err.Error())
+		}
+		s.signers[keyFile] = signer
+		return signer, nil
 	} else {
 		return nil, errors.New("failed to parse private key: " + err.Error())
 	}
 }
 
 func (s *SshDispatcher) Cleanup() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	var err error
 	for _, conn := range s.connections {
 		e := conn.Close()
@@ -118,6 +583,16 @@ func (s *SshDispatcher) Cleanup() error {
 			err = e
 		}
 	}
+	for _, conn := range s.jumpClients {
+		if e := conn.Close(); e != nil {
+			err = e
+		}
+	}
+	if s.agentConn != nil {
+		if e := s.agentConn.Close(); e != nil {
+			err = e
+		}
+	}
 	return err
 }
 
@@ -141,60 +616,119 @@ func (s *SshDispatcher) DownloadProject(node dispatch.Node, source string) error
 }
 
 func (s *SshDispatcher) GetMasterNode() dispatch.Node {
-	return dispatch.Node{Name: s.Remotes[0].FQDN, Kubename: "master", Remote: s.Remotes[0]}
+	return s.node(s.Remotes[0], 0)
 }
 
 func (s *SshDispatcher) GetNodes() []dispatch.Node {
-	return sliceutils.Map(s.Remotes, func(remote dispatch.UserQualifiedHostname, i int) dispatch.Node {
-		node := dispatch.Node{Name: remote.FQDN, Remote: remote}
-		if i == 0 {
-			node.Kubename = "master"
-		} else {
-			node.Kubename = fmt.Sprintf("worker-%d", i)
-		}
-		return node
-	})
+	return sliceutils.Map(s.Remotes, s.node)
 }
 
 func (s *SshDispatcher) GetWorkerNodes() []dispatch.Node {
 	return sliceutils.Map(s.Remotes[1:], func(remote dispatch.UserQualifiedHostname, i int) dispatch.Node {
-		return dispatch.Node{
-			Name:     remote.FQDN,
-			Kubename: fmt.Sprintf("worker-%d", i+1),
-			Remote:   remote,
-		}
+		return s.node(remote, i+1)
 	})
 }
 
+// node returns the node for the remote at an index of Remotes, with the
+// hostname its alias resolves to.
+func (s *SshDispatcher) node(remote dispatch.UserQualifiedHostname, idx int) dispatch.Node {
+	node := dispatch.Node{Name: remote.FQDN, Kubename: "master", Remote: remote}
+	if idx > 0 {
+		node.Kubename = fmt.Sprintf("worker-%d", idx)
+	}
+	if hostname := s.resolveHost(remote).hostname; hostname != remote.FQDN {
+		node.Hostname = hostname
+	}
+	return node
+}
+
+// Ready reports whether every node is connected, or only the master node if
+// unreachable nodes are allowed.
 func (s *SshDispatcher) Ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.AllowUnreachable {
+		_, ok := s.connections[s.Remotes[0].FQDN]
+		return ok
+	}
 	return len(s.connections) == s.NumNodes
 }
 
+// Reachable reports whether a node was connected to. Only nodes that couldn't
+// be connected to when unreachable nodes are allowed aren't.
+func (s *SshDispatcher) Reachable(node dispatch.Node) bool {
+	_, ok := s.connection(node)
+	return ok
+}
+
+func (s *SshDispatcher) Status(ctx context.Context) []dispatch.NodeStatus {
+	nodes := s.GetNodes()
+	statuses := make([]dispatch.NodeStatus, len(nodes))
+	var wg sync.WaitGroup
+	for idx, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[idx] = s.nodeStatus(ctx, node)
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+// nodeStatus checks the health of a single node. Running the uptime command
+// redials the node if its connection dropped, so the reported state reflects
+// whether the node can be reached and authenticated with right now.
+func (s *SshDispatcher) nodeStatus(ctx context.Context, node dispatch.Node) dispatch.NodeStatus {
+	status := dispatch.NodeStatus{Node: node}
+	var uptime strings.Builder
+	if err := s.SendCommandsContext(
+		ctx,
+		node,
+		dispatch.NewCommand(
+			dispatch.UptimeCmd,
+			dispatch.WithStdout(&uptime),
+			dispatch.WithTimeout(10*time.Second),
+		),
+	); err != nil {
+		status.State = "unreachable"
+		status.Err = err
+		return status
+	}
+	status.Reachable = true
+	status.State = "authenticated as " + s.resolveHost(node.Remote).user
+	status.Uptime, status.Err = dispatch.ParseUptime(uptime.String())
+
+	client, _ := s.connection(node)
+	start := time.Now()
+	if !isAlive(client, s.KeepaliveInterval) {
+		status.Err = errors.New("keepalive request timed out")
+	}
+	status.Latency = time.Since(start)
+	return status
+}
+
 func (s *SshDispatcher) SendCommands(node dispatch.Node, cmds ...dispatch.Command) error {
 	return s.SendCommandsContext(context.Background(), node, cmds...)
 }
 
 func (s *SshDispatcher) SendCommandsContext(ctx context.Context, node dispatch.Node, cmds ...dispatch.Command) error {
 	for _, cmd := range cmds {
-		client, ok := s.connections[node.Name]
-		if !ok {
-			return errors.New("no connection found for node " + node.Name)
-		}
-		session, err := client.NewSession()
+		session, err := s.newSession(node)
 		if err != nil {
 			return errors.New(fmt.Sprintf("failed to create session for %s: %v", node.Name, err))
 		}
 		defer session.Close()
-		session.Stdout = cmd.Stdout()
-		session.Stderr = cmd.Stderr()
 		if cmd.Timeout() > 0 {
 			time.AfterFunc(cmd.Timeout(), func() {
 				session.Signal(ssh.SIGTERM)
 			})
 		}
-		if err := session.Run(
-			fmt.Sprintf("%s %s", stringutils.BuildEnvBindings(cmd.Env()), cmd.Cmd()),
-		); err != nil {
+		if err := dispatch.RunCommand(node, cmd, func(stdout, stderr io.Writer) error {
+			session.Stdout = stdout
+			session.Stderr = stderr
+			return session.Run(cmd.Line())
+		}); err != nil {
 			return err
 		}
 	}
@@ -203,14 +737,40 @@ func (s *SshDispatcher) SendCommandsContext(ctx context.Context, node dispatch.N
 
 func (s *SshDispatcher) SendFile(node dispatch.Node, src string, dst string) error {
 	var errBytes bytes.Buffer
-	cmd := exec.Command("scp", "-i", s.PrivateKeyFile, src, fmt.Sprintf("%s:%s", node.Name, dst))
+	cmd := exec.Command("scp", s.scpArgs(node, src, fmt.Sprintf("%s:%s", node.Name, dst))...)
 	cmd.Stderr = &errBytes
-	if err := cmd.Run(); err != nil {
+	start := time.Now()
+	err := cmd.Run()
+	slog.Debug("sent file", "node", node.Name, "src", src, "dst", dst, "duration", time.Since(start))
+	if err != nil {
 		return errors.New(errBytes.String())
 	}
 	return nil
 }
 
+// scpArgs prepends the identity file and jump host flags to the scp arguments
+// for the node if they were provided. Otherwise, scp resolves keys and jump hosts
+// through the agent and SSH config on its own.
+func (s *SshDispatcher) scpArgs(node dispatch.Node, args ...string) []string {
+	var flags []string
+	if s.PrivateKeyFile != "" {
+		flags = append(flags, "-i", s.PrivateKeyFile)
+	}
+	if node.Remote.Port != "" {
+		flags = append(flags, "-P", node.Remote.Port)
+	}
+	// A ProxyJump in the SSH config takes precedence, which scp applies itself.
+	if len(s.JumpHosts) > 0 && s.config().Get(node.Name, "ProxyJump") == "" {
+		flags = append(flags, "-J", strings.Join(
+			sliceutils.Map(s.JumpHosts, func(jumpHost dispatch.UserQualifiedHostname, _ int) string {
+				return jumpHost.String()
+			}),
+			",",
+		))
+	}
+	return append(flags, args...)
+}
+
 func (s *SshDispatcher) downloadProjectLocal(node dispatch.Node, source string) error {
 	src := strings.TrimPrefix(source, "local://")
 	path, err := pathutils.AbsolutePath(src)
@@ -227,14 +787,11 @@ func (s *SshDispatcher) downloadProjectLocal(node dispatch.Node, source string)
 	}
 	scpCmd := exec.Command(
 		"scp",
-		"-i",
-		s.PrivateKeyFile,
-		"-r",
-		path,
-		fmt.Sprintf("%s:~/projects/%s", node.Name, basePath),
+		s.scpArgs(node, "-r", path, fmt.Sprintf("%s:~/projects/%s", node.Name, basePath))...,
 	)
-	scpCmd.Stdout = dispatch.NewPrefixWriter(node.Name, os.Stdout)
-	scpCmd.Stderr = dispatch.NewPrefixWriter(node.Name, os.Stderr)
+	scpCmd.Stdout = dispatch.Output.Writer(node.Name, os.Stdout)
+	scpCmd.Stderr = dispatch.Output.Writer(node.Name, os.Stderr)
+	defer dispatch.Flush(scpCmd.Stdout, scpCmd.Stderr)
 	if err := scpCmd.Run(); err != nil {
 		return err
 	}
@@ -0,0 +1,201 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kev-cao/log-console/deploy-cli/dispatch"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshServer is an SSH server that accepts any public key and rejects every
+// session.
+type sshServer struct {
+	port string
+	// hung stops the server from answering on its connections.
+	hung chan struct{}
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// serveSSH starts an SSH server.
+func serveSSH(t *testing.T) *sshServer {
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	server := &sshServer{hung: make(chan struct{})}
+	server.port = listen(t, func(conn net.Conn) {
+		server.mu.Lock()
+		server.conns = append(server.conns, conn)
+		server.mu.Unlock()
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range reqs {
+				select {
+				case <-server.hung:
+					// Not reading requests blocks the whole connection.
+					<-make(chan struct{})
+				default:
+					req.Reply(false, nil)
+				}
+			}
+		}()
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "no sessions")
+		}
+	})
+	return server
+}
+
+// connections returns how many connections the server has accepted.
+func (s *sshServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// drop closes every connection to the server, like a node that rebooted.
+func (s *sshServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// hang stops the server from answering, like a node that froze.
+func (s *sshServer) hang() {
+	close(s.hung)
+}
+
+// serveSilently starts a server that accepts connections but never answers,
+// like a host that hangs during the SSH handshake, and returns its port.
+func serveSilently(t *testing.T) string {
+	return listen(t, func(conn net.Conn) {})
+}
+
+func listen(t *testing.T, handle func(net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go handle(conn)
+		}
+	}()
+	return fmt.Sprint(l.Addr().(*net.TCPAddr).Port)
+}
+
+// newTestDispatcher creates a dispatcher for the remotes, which are aliases of
+// ports on localhost.
+func newTestDispatcher(t *testing.T, ports map[string]string, remotes ...string) *SshDispatcher {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	dir := t.TempDir()
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600))
+
+	var config strings.Builder
+	for alias, port := range ports {
+		fmt.Fprintf(&config, "Host %s\n\tHostName 127.0.0.1\n\tPort %s\n", alias, port)
+	}
+	// Keeps the user's own keys out of the test.
+	fmt.Fprintf(&config, "Host *\n\tIdentityFile %s\n", filepath.Join(dir, "missing"))
+	configFile := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(configFile, []byte(config.String()), 0600))
+
+	s := &SshDispatcher{
+		NumNodes:         len(remotes),
+		PrivateKeyFile:   keyFile,
+		SshConfigFile:    configFile,
+		DialTimeout:      300 * time.Millisecond,
+		AllowUnreachable: true,
+	}
+	for _, remote := range remotes {
+		s.Remotes = append(s.Remotes, dispatch.UserQualifiedHostname{FQDN: remote})
+	}
+	t.Cleanup(func() { s.Cleanup() })
+	return s
+}
+
+func TestSshDispatcherUnreachable(t *testing.T) {
+	ports := map[string]string{
+		"pi-master":   serveSSH(t).port,
+		"pi-worker-1": serveSilently(t),
+		"pi-worker-2": serveSilently(t),
+		"pi-worker-3": serveSilently(t),
+	}
+	s := newTestDispatcher(t, ports, "pi-master", "pi-worker-1", "pi-worker-2", "pi-worker-3")
+	start := time.Now()
+	require.NoError(t, s.init())
+	// The unreachable nodes time out in parallel, without holding up the
+	// reachable one.
+	require.Less(t, time.Since(start), 2*s.DialTimeout)
+	require.Contains(t, s.connections, "pi-master")
+	require.Len(t, s.connections, 1)
+	// Deployments can go ahead with the reachable nodes.
+	require.True(t, s.Ready())
+	nodes := s.GetNodes()
+	require.True(t, s.Reachable(nodes[0]))
+	require.False(t, s.Reachable(nodes[1]))
+
+	s = newTestDispatcher(t, ports, "pi-worker-1", "pi-master")
+	require.NoError(t, s.init())
+	// Nothing can be deployed without the master node.
+	require.False(t, s.Ready())
+
+	s = newTestDispatcher(t, ports, "pi-master", "pi-worker-1")
+	s.AllowUnreachable = false
+	require.ErrorContains(t, s.init(), "failed to connect to pi-worker-1: timed out")
+}
+
+func TestSshDispatcherRedial(t *testing.T) {
+	server := serveSSH(t)
+	s := newTestDispatcher(t, map[string]string{"pi-master": server.port}, "pi-master")
+	require.NoError(t, s.init())
+	master := s.GetMasterNode()
+	client := s.connections["pi-master"]
+
+	// A session rejected by a live remote leaves the connection to the other
+	// sessions on it.
+	_, err := s.newSession(master)
+	var rejected *ssh.OpenChannelError
+	require.ErrorAs(t, err, &rejected)
+	require.Same(t, client, s.connections["pi-master"])
+	require.Equal(t, 1, server.connections())
+
+	// A dropped connection is redialed.
+	server.drop()
+	_, err = s.newSession(master)
+	require.ErrorAs(t, err, &rejected)
+	require.NotSame(t, client, s.connections["pi-master"])
+	require.Equal(t, 2, server.connections())
+}
@@ -0,0 +1,82 @@
+package dispatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NodeStatus is a health report for a single node in the cluster.
+type NodeStatus struct {
+	Node Node
+	// Reachable is true if a command could be run on the node.
+	Reachable bool
+	// Latency is the round trip time of a no-op request to the node.
+	Latency time.Duration
+	// State is the state of the node as reported by the dispatcher, e.g. the VM
+	// state for multipass or the authentication result for SSH.
+	State string
+	// Uptime is how long the node has been running. Zero if unknown.
+	Uptime time.Duration
+	// Err is the reason the node is unhealthy, if any.
+	Err error
+}
+
+// Healthy returns true if the node is reachable and did not report an error.
+func (s NodeStatus) Healthy() bool {
+	return s.Reachable && s.Err == nil
+}
+
+// String returns a one-line summary of the node's status.
+func (s NodeStatus) String() string {
+	if s.Healthy() {
+		return fmt.Sprintf("%s: %s (latency %s, up %s)", s.Node.Name, s.State, s.Latency, s.Uptime)
+	}
+	return fmt.Sprintf("%s: %s (%v)", s.Node.Name, s.State, s.Err)
+}
+
+// MarshalJSON encodes the status with durations in milliseconds and the error
+// as its message.
+func (s NodeStatus) MarshalJSON() ([]byte, error) {
+	status := struct {
+		Node      string `json:"node"`
+		Kubename  string `json:"kubename"`
+		Healthy   bool   `json:"healthy"`
+		Reachable bool   `json:"reachable"`
+		LatencyMs int64  `json:"latency_ms"`
+		State     string `json:"state"`
+		UptimeMs  int64  `json:"uptime_ms"`
+		Error     string `json:"error,omitempty"`
+	}{
+		Node:      s.Node.Name,
+		Kubename:  s.Node.Kubename,
+		Healthy:   s.Healthy(),
+		Reachable: s.Reachable,
+		LatencyMs: s.Latency.Milliseconds(),
+		State:     s.State,
+		UptimeMs:  s.Uptime.Milliseconds(),
+	}
+	if s.Err != nil {
+		status.Error = s.Err.Error()
+	}
+	return json.Marshal(status)
+}
+
+// UptimeCmd is the command run on a node to check its uptime. It doubles as a
+// reachability check.
+const UptimeCmd = "cat /proc/uptime"
+
+// ParseUptime parses the output of UptimeCmd.
+func ParseUptime(s string) (time.Duration, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid uptime: %q", s)
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid uptime: %w", err)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
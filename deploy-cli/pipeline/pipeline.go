@@ -0,0 +1,244 @@
+// Package pipeline runs a deployment as a sequence of named steps, so that a
+// deployment that failed partway through can be picked up again by selecting
+// the steps to run.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// Step is a named stage of a pipeline.
+type Step struct {
+	Name string
+	// Title is printed when the step starts.
+	Title string
+	// Deps are the steps that must have run before the step, either earlier in
+	// the same run or in a previous one. They must come before the step. Deps
+	// that aren't enabled are assumed to have been done outside the pipeline,
+	// e.g. K3S installed by hand.
+	Deps []string
+	// Enabled reports whether the step runs unless selected by name. Steps
+	// without it always run.
+	Enabled func() bool
+	Run     func() error
+	// Undo undoes what the step does, or what it did before failing. Steps
+	// without it are left in place when a pipeline is rolled back.
+	Undo func() error
+}
+
+// Selection selects the steps of a pipeline to run. Without any of its fields
+// set, every enabled step runs.
+type Selection struct {
+	// Only runs just the named steps, whether enabled or not.
+	Only []string
+	// Skip skips the named steps.
+	Skip []string
+	// From skips the steps before the named one.
+	From string
+	// Resume skips the steps up to the last one that completed in a previous
+	// run, according to the pipeline's state.
+	Resume bool
+}
+
+// Pipeline is an ordered list of steps.
+type Pipeline struct {
+	steps []Step
+	// Start is called before each step runs.
+	Start func(Step)
+	// StartUndo is called before each step is undone.
+	StartUndo func(Step)
+	// Before and After are called before and after each step runs, e.g. to run
+	// user hooks. An error from either fails the step, and a step only
+	// completes once After returns.
+	Before func(Step) error
+	After  func(Step) error
+	// State records the steps that completed. It's saved to Store, if set,
+	// whenever a step completes.
+	State *State
+	Store Store
+}
+
+// New creates a pipeline that runs the steps in order.
+func New(steps ...Step) (*Pipeline, error) {
+	seen := make(map[string]bool)
+	for _, step := range steps {
+		if seen[step.Name] {
+			return nil, fmt.Errorf("duplicate step %s", step.Name)
+		}
+		for _, dep := range step.Deps {
+			if !seen[dep] {
+				return nil, fmt.Errorf("step %s depends on %s, which does not come before it", step.Name, dep)
+			}
+		}
+		seen[step.Name] = true
+	}
+	return &Pipeline{steps: steps, State: NewState()}, nil
+}
+
+// Names returns the names of the steps of the pipeline in order.
+func (p *Pipeline) Names() []string {
+	names := make([]string, len(p.steps))
+	for i, step := range p.steps {
+		names[i] = step.Name
+	}
+	return names
+}
+
+// Select returns the steps a selection runs, in order.
+func (p *Pipeline) Select(sel Selection) ([]Step, error) {
+	names := p.Names()
+	for _, name := range append(append([]string{sel.From}, sel.Only...), sel.Skip...) {
+		if name != "" && !slices.Contains(names, name) {
+			return nil, fmt.Errorf("unknown step %s. Steps: %v", name, names)
+		}
+	}
+	if sel.Resume && (sel.From != "" || len(sel.Only) > 0) {
+		return nil, errors.New("resuming cannot be combined with selecting steps to run from or only")
+	}
+	from := 0
+	if sel.From != "" {
+		from = slices.Index(names, sel.From)
+	} else if sel.Resume {
+		for i, name := range names {
+			if _, ok := p.State.Completed[name]; ok {
+				from = i + 1
+			}
+		}
+	}
+	var selected []Step
+	for _, step := range p.steps[from:] {
+		if slices.Contains(sel.Skip, step.Name) {
+			continue
+		}
+		if len(sel.Only) > 0 {
+			if slices.Contains(sel.Only, step.Name) {
+				selected = append(selected, step)
+			}
+		} else if step.Enabled == nil || step.Enabled() {
+			selected = append(selected, step)
+		}
+	}
+	if err := p.checkDeps(selected); err != nil {
+		return nil, err
+	}
+	return selected, nil
+}
+
+// checkDeps checks that the dependencies of the selected steps run before
+// them, or have completed and won't have to run again because of a selected
+// step before them.
+func (p *Pipeline) checkDeps(selected []Step) error {
+	completed := make(map[string]bool, len(p.State.Completed))
+	for name := range p.State.Completed {
+		completed[name] = true
+	}
+	for i, step := range p.steps {
+		if !slices.ContainsFunc(selected, func(s Step) bool { return s.Name == step.Name }) {
+			continue
+		}
+		for _, dep := range step.Deps {
+			depStep := p.steps[slices.Index(p.Names(), dep)]
+			if completed[dep] || (depStep.Enabled != nil && !depStep.Enabled()) {
+				continue
+			}
+			return fmt.Errorf("step %s depends on step %s, which is not selected and has not completed", step.Name, dep)
+		}
+		// Like Run, running the step means the steps after it have to run
+		// again.
+		for _, later := range p.steps[i:] {
+			delete(completed, later.Name)
+		}
+		completed[step.Name] = true
+	}
+	return nil
+}
+
+// Failure is the error of a pipeline run that stopped at a failed step.
+type Failure struct {
+	Step string
+	// Applied are the steps the run applied, in order, ending with the failed
+	// step, which may have been partially applied. The failed step is left out
+	// if it failed before it ran.
+	Applied []Step
+	Err     error
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("step %s failed: %v", f.Step, f.Err)
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// Run runs the selected steps in order, stopping at the first one that fails
+// with a *Failure.
+func (p *Pipeline) Run(sel Selection) error {
+	steps, err := p.Select(sel)
+	if err != nil {
+		return err
+	}
+	for i, step := range steps {
+		// Steps after this one may depend on what it does, so they have to run
+		// again too.
+		for _, later := range p.steps[slices.Index(p.Names(), step.Name):] {
+			delete(p.State.Completed, later.Name)
+		}
+		if p.Start != nil {
+			p.Start(step)
+		}
+		if p.Before != nil {
+			if err := p.Before(step); err != nil {
+				return &Failure{Step: step.Name, Applied: steps[:i], Err: err}
+			}
+		}
+		if err := step.Run(); err != nil {
+			return &Failure{Step: step.Name, Applied: steps[:i+1], Err: err}
+		}
+		if p.After != nil {
+			if err := p.After(step); err != nil {
+				return &Failure{Step: step.Name, Applied: steps[:i+1], Err: err}
+			}
+		}
+		p.State.Completed[step.Name] = time.Now()
+		p.save(step)
+	}
+	return nil
+}
+
+// Undo undoes steps in reverse order, e.g. the steps applied by a failed run.
+// Steps that fail to be undone don't stop the others from being undone.
+func (p *Pipeline) Undo(steps []Step) error {
+	var errs []error
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if step.Undo == nil {
+			continue
+		}
+		if p.StartUndo != nil {
+			p.StartUndo(step)
+		}
+		if err := step.Undo(); err != nil {
+			errs = append(errs, fmt.Errorf("error undoing step %s: %w", step.Name, err))
+			continue
+		}
+		delete(p.State.Completed, step.Name)
+		p.save(step)
+	}
+	return errors.Join(errs...)
+}
+
+// save saves the state after a step completed or was undone.
+func (p *Pipeline) save(step Step) {
+	if p.Store == nil {
+		return
+	}
+	p.State.Updated = time.Now()
+	if err := p.Store.Save(p.State); err != nil {
+		slog.Warn("Could not save the deployment state", "step", step.Name, "err", err)
+	}
+}
@@ -0,0 +1,171 @@
+package pipeline
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	var ran []string
+	step := func(name string, enabled bool, deps ...string) Step {
+		return Step{
+			Name:    name,
+			Deps:    deps,
+			Enabled: func() bool { return enabled },
+			Run: func() error {
+				ran = append(ran, name)
+				return nil
+			},
+		}
+	}
+	p, err := New(
+		step("launch", false),
+		step("k3s", true, "launch"),
+		step("init", true, "k3s"),
+		step("auth", true, "init"),
+	)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		sel      Selection
+		expected []string
+	}{
+		{"all", Selection{}, []string{"k3s", "init", "auth"}},
+		{"only", Selection{Only: []string{"launch", "k3s"}}, []string{"launch", "k3s"}},
+		{"skip", Selection{Skip: []string{"auth"}}, []string{"k3s", "init"}},
+		{"from", Selection{From: "init"}, []string{"init", "auth"}},
+		{"from and skip", Selection{From: "init", Skip: []string{"init"}}, []string{"auth"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ran = nil
+			require.NoError(t, p.Run(tc.sel))
+			require.Equal(t, tc.expected, ran)
+		})
+	}
+
+	_, err = p.Select(Selection{From: "vault"})
+	require.ErrorContains(t, err, "unknown step vault")
+
+	// Running k3s again means init has to run again before auth.
+	_, err = p.Select(Selection{Only: []string{"k3s", "auth"}})
+	require.ErrorContains(t, err, "step auth depends on step init, which is not selected and has not completed")
+	_, err = p.Select(Selection{Skip: []string{"init"}})
+	require.ErrorContains(t, err, "step auth depends on step init")
+	// Steps that aren't enabled are assumed to be done.
+	p.State = NewState()
+	_, err = p.Select(Selection{Only: []string{"k3s"}})
+	require.NoError(t, err)
+	_, err = p.Select(Selection{From: "init"})
+	require.ErrorContains(t, err, "step init depends on step k3s")
+
+	_, err = New(step("init", true, "k3s"), step("k3s", true))
+	require.Error(t, err)
+}
+
+func TestPipelineFailure(t *testing.T) {
+	var ran []string
+	p, err := New(
+		Step{Name: "a", Run: func() error { ran = append(ran, "a"); return errors.New("boom") }},
+		Step{Name: "b", Run: func() error { ran = append(ran, "b"); return nil }},
+	)
+	require.NoError(t, err)
+	require.ErrorContains(t, p.Run(Selection{}), "step a failed: boom")
+	require.Equal(t, []string{"a"}, ran)
+}
+
+func TestPipelineResume(t *testing.T) {
+	var ran []string
+	step := func(name string, err error) Step {
+		return Step{Name: name, Run: func() error {
+			ran = append(ran, name)
+			return err
+		}}
+	}
+	store := FileStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	p, err := New(step("k3s", nil), step("init", nil), step("cert_watcher", errors.New("boom")))
+	require.NoError(t, err)
+	p.Store = store
+	require.Error(t, p.Run(Selection{}))
+
+	state, err := store.Load()
+	require.NoError(t, err)
+	require.Contains(t, state.Completed, "init")
+	require.NotContains(t, state.Completed, "cert_watcher")
+
+	ran = nil
+	p, err = New(step("k3s", nil), step("init", nil), step("cert_watcher", nil))
+	require.NoError(t, err)
+	p.State = state
+	require.NoError(t, p.Run(Selection{Resume: true}))
+	require.Equal(t, []string{"cert_watcher"}, ran)
+
+	_, err = p.Select(Selection{Resume: true, From: "init"})
+	require.Error(t, err)
+}
+
+func TestPipelineUndo(t *testing.T) {
+	var undone []string
+	step := func(name string, err error, undo bool) Step {
+		s := Step{Name: name, Run: func() error { return err }}
+		if undo {
+			s.Undo = func() error {
+				undone = append(undone, name)
+				return nil
+			}
+		}
+		return s
+	}
+	p, err := New(
+		step("k3s", nil, true),
+		step("dependencies", nil, false),
+		step("cert_manager", nil, true),
+		step("init", errors.New("boom"), true),
+		step("auth", nil, true),
+	)
+	require.NoError(t, err)
+	err = p.Run(Selection{From: "dependencies"})
+	var failure *Failure
+	require.ErrorAs(t, err, &failure)
+	require.Equal(t, "init", failure.Step)
+	require.Len(t, failure.Applied, 3)
+
+	require.NoError(t, p.Undo(failure.Applied))
+	require.Equal(t, []string{"init", "cert_manager"}, undone)
+	// Steps without an undo are still applied.
+	require.Contains(t, p.State.Completed, "dependencies")
+	require.NotContains(t, p.State.Completed, "cert_manager")
+}
+
+func TestPipelineHooks(t *testing.T) {
+	var ran []string
+	step := func(name string) Step {
+		return Step{Name: name, Run: func() error {
+			ran = append(ran, name)
+			return nil
+		}}
+	}
+	p, err := New(step("k3s"), step("init"), step("auth"))
+	require.NoError(t, err)
+	p.Before = func(step Step) error {
+		ran = append(ran, "before "+step.Name)
+		if step.Name == "auth" {
+			return errors.New("boom")
+		}
+		return nil
+	}
+	p.After = func(step Step) error {
+		ran = append(ran, "after "+step.Name)
+		return nil
+	}
+	err = p.Run(Selection{})
+	var failure *Failure
+	require.ErrorAs(t, err, &failure)
+	require.Equal(t, []string{"before k3s", "k3s", "after k3s", "before init", "init", "after init", "before auth"}, ran)
+	// The failed step never ran, so it wasn't applied.
+	require.Len(t, failure.Applied, 2)
+	require.Contains(t, p.State.Completed, "init")
+}
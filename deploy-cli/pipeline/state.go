@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State is what a pipeline has done, kept between runs so that a pipeline can
+// be resumed.
+type State struct {
+	Updated time.Time `json:"updated"`
+	// Completed maps the steps that completed to when they did.
+	Completed map[string]time.Time `json:"completed"`
+	// Outputs are values produced by steps, e.g. tokens read from the cluster.
+	Outputs map[string]string `json:"outputs"`
+
+	mu sync.Mutex
+}
+
+// NewState creates an empty state.
+func NewState() *State {
+	return &State{
+		Completed: make(map[string]time.Time),
+		Outputs:   make(map[string]string),
+	}
+}
+
+// Output returns an output set by a step.
+func (s *State) Output(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.Outputs[key]
+	return value, ok
+}
+
+// SetOutput sets an output of a step.
+func (s *State) SetOutput(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Outputs[key] = value
+}
+
+// DeleteOutput deletes an output set by a step.
+func (s *State) DeleteOutput(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Outputs, key)
+}
+
+// Copy returns a copy of the state.
+func (s *State) Copy() *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := NewState()
+	c.Updated = s.Updated
+	for name, at := range s.Completed {
+		c.Completed[name] = at
+	}
+	for key, value := range s.Outputs {
+		c.Outputs[key] = value
+	}
+	return c
+}
+
+// CopyOutputs returns a copy of the outputs set by steps.
+func (s *State) CopyOutputs() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	outputs := make(map[string]string, len(s.Outputs))
+	for key, value := range s.Outputs {
+		outputs[key] = value
+	}
+	return outputs
+}
+
+// UnmarshalState decodes a state encoded as JSON.
+func UnmarshalState(b []byte) (*State, error) {
+	s := NewState()
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if s.Completed == nil {
+		s.Completed = make(map[string]time.Time)
+	}
+	if s.Outputs == nil {
+		s.Outputs = make(map[string]string)
+	}
+	return s, nil
+}
+
+// Store persists the state of a pipeline.
+type Store interface {
+	// Load returns the stored state, or nil if there is none.
+	Load() (*State, error)
+	Save(s *State) error
+}
+
+// FileStore stores the state of a pipeline in a JSON file. Outputs may hold
+// secrets, so the file is only readable by its owner.
+type FileStore struct {
+	Path string
+}
+
+var _ Store = FileStore{}
+
+func (f FileStore) Load() (*State, error) {
+	b, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return UnmarshalState(b)
+}
+
+func (f FileStore) Save(s *State) error {
+	b, err := s.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(f.Path, b, 0600)
+}
+
+// Delete deletes the stored state, if there is any.
+func (f FileStore) Delete() error {
+	if err := os.Remove(f.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// MarshalJSON encodes the state, indented for reading by hand.
+func (s *State) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type state State
+	return json.MarshalIndent((*state)(s), "", "    ")
+}
@@ -0,0 +1,91 @@
+package transcript
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	secretsMu sync.RWMutex
+	// secrets are values learned during a run that must never be recorded,
+	// e.g. the Vault root token or the K3S node token.
+	secrets []string
+)
+
+// secretPatterns match secrets that are printed or sent before they can be added
+// with AddSecret. The first submatch of each pattern is redacted.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`Initial Root Token: ([^\s"]+)`),
+	regexp.MustCompile(`Recovery Key \d+: ([^\s"]+)`),
+	regexp.MustCompile(`K3S_TOKEN="([^"]*)"`),
+	// K3S node tokens and Vault service tokens.
+	regexp.MustCompile(`(K10[0-9a-f]+::\S+)`),
+	regexp.MustCompile(`\b(hvs\.[A-Za-z0-9_-]+)`),
+	regexp.MustCompile(`(?i)\b(?:vault_token|token|password)=([^\s"]+)`),
+}
+
+// secretFlagPattern matches the names of flags whose values are secrets.
+var secretFlagPattern = regexp.MustCompile(`(?i)token|password|secret|pat\b`)
+
+// AddSecret adds a value that is redacted from everything recorded from now on.
+func AddSecret(secret string) {
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// Redact replaces known secrets and values that look like secrets in s.
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	secretsMu.RUnlock()
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllStringFunc(s, func(match string) string {
+			submatch := pattern.FindStringSubmatchIndex(match)
+			return match[:submatch[2]] + redacted + match[submatch[3]:]
+		})
+	}
+	return s
+}
+
+func redactAll(ss []string) []string {
+	redactedSs := make([]string, len(ss))
+	for i, s := range ss {
+		redactedSs[i] = Redact(s)
+	}
+	return redactedSs
+}
+
+func isSecretFlag(name string) bool {
+	return secretFlagPattern.MatchString(name)
+}
+
+// redactingWriter redacts everything written through it. Secrets are only
+// redacted if they're contained in a single write, which holds for the log
+// since each record is written at once.
+type redactingWriter struct {
+	w io.Writer
+}
+
+// NewRedactingWriter returns a writer that redacts secrets from everything
+// written through it to w, as they are redacted from the transcript.
+func NewRedactingWriter(w io.Writer) io.Writer {
+	return redactingWriter{w}
+}
+
+func (r redactingWriter) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,47 @@
+package transcript
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	AddSecret("hvs.learned")
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "learned secret",
+			input:    "export VAULT_ADDR=https://127.0.0.1:8200; vault login hvs.learned",
+			expected: "export VAULT_ADDR=https://127.0.0.1:8200; vault login [REDACTED]",
+		},
+		{
+			name:     "vault init output",
+			input:    "Recovery Key 1: abc+def=\nInitial Root Token: hvs.printed\n",
+			expected: "Recovery Key 1: [REDACTED]\nInitial Root Token: [REDACTED]\n",
+		},
+		{
+			name:     "k3s agent install",
+			input:    `curl -sfL https://get.k3s.io | K3S_URL="https://master:6443" K3S_TOKEN="K10abc::server:def" sh -`,
+			expected: `curl -sfL https://get.k3s.io | K3S_URL="https://master:6443" K3S_TOKEN="[REDACTED]" sh -`,
+		},
+		{
+			name:     "k3s node token",
+			input:    "K10f3a9::server:0123abcd\n",
+			expected: "[REDACTED]\n",
+		},
+		{
+			name:     "vault write arguments",
+			input:    "vault write auth/userpass/users/admin password=hunter2 policies=admin",
+			expected: "vault write auth/userpass/users/admin password=[REDACTED] policies=admin",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expected, Redact(test.input))
+		})
+	}
+}
@@ -0,0 +1,291 @@
+// Package transcript records runs of deploy-cli to disk so that failed runs can
+// be inspected after the fact. Each run gets its own directory holding the
+// resolved flags, every command sent to each node along with its exit status,
+// timing and output, and the run's log. Known secrets are redacted before
+// anything is written.
+package transcript
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	runFile      = "run.json"
+	commandsFile = "commands.jsonl"
+	logFile      = "output.log"
+)
+
+// Run describes a single run of deploy-cli.
+type Run struct {
+	ID       string            `json:"id"`
+	Command  string            `json:"command"`
+	Args     []string          `json:"args"`
+	Flags    map[string]string `json:"flags"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished,omitempty"`
+	// Error is the error the run failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Status returns whether the run succeeded, failed, or never finished, e.g.
+// because it's still running or was killed.
+func (r Run) Status() string {
+	switch {
+	case r.Finished.IsZero():
+		return "incomplete"
+	case r.Error != "":
+		return "failed"
+	default:
+		return "succeeded"
+	}
+}
+
+// Command is a single command sent to a node during a run.
+type Command struct {
+	Node    string `json:"node"`
+	Command string `json:"command"`
+	// Env is the keys of the command's environment. Values are not recorded.
+	Env        []string      `json:"env,omitempty"`
+	Started    time.Time     `json:"started"`
+	Duration   time.Duration `json:"duration"`
+	ExitStatus int           `json:"exit_status"`
+	Error      string        `json:"error,omitempty"`
+	Stdout     string        `json:"stdout,omitempty"`
+	Stderr     string        `json:"stderr,omitempty"`
+}
+
+// StateDir returns the directory deploy-cli keeps its state in. It follows the
+// XDG base directory spec, defaulting to ~/.local/state/deploy-cli.
+func StateDir() (string, error) {
+	state := os.Getenv("XDG_STATE_HOME")
+	if state == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		state = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(state, "deploy-cli"), nil
+}
+
+// Dir returns the directory runs are recorded in.
+func Dir() (string, error) {
+	state, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(state, "runs"), nil
+}
+
+// Recorder records a run to its directory.
+type Recorder struct {
+	dir      string
+	run      Run
+	mu       sync.Mutex
+	commands *os.File
+	log      *os.File
+}
+
+// Start creates the directory for a new run and records its flags. Values of
+// flags that look like secrets are redacted.
+func Start(command string, args []string, flags map[string]string) (*Recorder, error) {
+	runsDir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(runsDir, 0700); err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	id := started.Format("20060102-150405")
+	dir := filepath.Join(runsDir, id)
+	// Runs started within the same second get a numeric suffix.
+	for i := 2; ; i++ {
+		err = os.Mkdir(dir, 0700)
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", started.Format("20060102-150405"), i)
+		dir = filepath.Join(runsDir, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range flags {
+		if isSecretFlag(name) {
+			AddSecret(value)
+		}
+	}
+	redactedFlags := make(map[string]string, len(flags))
+	for name, value := range flags {
+		redactedFlags[name] = Redact(value)
+	}
+	r := &Recorder{
+		dir: dir,
+		run: Run{
+			ID:      id,
+			Command: command,
+			Args:    redactAll(args),
+			Flags:   redactedFlags,
+			Started: started,
+		},
+	}
+	if r.commands, err = os.OpenFile(filepath.Join(dir, commandsFile), os.O_CREATE|os.O_WRONLY, 0600); err != nil {
+		return nil, err
+	}
+	if r.log, err = os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY, 0600); err != nil {
+		r.commands.Close()
+		return nil, err
+	}
+	if err := r.writeRun(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// ID returns the ID of the run being recorded.
+func (r *Recorder) ID() string {
+	return r.run.ID
+}
+
+// Log returns a writer for the run's log. Everything written to it is redacted.
+func (r *Recorder) Log() io.Writer {
+	return NewRedactingWriter(r.log)
+}
+
+// RecordCommand records a command that was sent to a node.
+func (r *Recorder) RecordCommand(c Command) error {
+	c.Command = Redact(c.Command)
+	c.Error = Redact(c.Error)
+	c.Stdout = Redact(c.Stdout)
+	c.Stderr = Redact(c.Stderr)
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.commands.Write(append(b, '\n'))
+	return err
+}
+
+// Finish records the end of the run and the error it failed with, if any, and
+// closes the run's files.
+func (r *Recorder) Finish(runErr error) error {
+	r.mu.Lock()
+	r.run.Finished = time.Now()
+	if runErr != nil {
+		r.run.Error = Redact(runErr.Error())
+	}
+	r.mu.Unlock()
+	return errors.Join(r.writeRun(), r.Close())
+}
+
+// Close closes the run's files without finishing it.
+func (r *Recorder) Close() error {
+	return errors.Join(r.commands.Close(), r.log.Close())
+}
+
+func (r *Recorder) writeRun() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := json.MarshalIndent(r.run, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.dir, runFile), b, 0600)
+}
+
+// List returns the recorded runs, most recent first.
+func List() ([]Run, error) {
+	runsDir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(runsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var runs []Run
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		run, err := loadRun(filepath.Join(runsDir, entry.Name()))
+		if err != nil {
+			// Skip directories that aren't runs rather than failing the listing.
+			continue
+		}
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Started.After(runs[j].Started)
+	})
+	return runs, nil
+}
+
+// Load returns a recorded run and the commands sent during it.
+func Load(id string) (Run, []Command, error) {
+	runsDir, err := Dir()
+	if err != nil {
+		return Run{}, nil, err
+	}
+	dir := filepath.Join(runsDir, filepath.Base(id))
+	run, err := loadRun(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return Run{}, nil, fmt.Errorf("run %s does not exist", id)
+	} else if err != nil {
+		return Run{}, nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, commandsFile))
+	if err != nil {
+		return Run{}, nil, err
+	}
+	defer f.Close()
+	var commands []Command
+	scanner := bufio.NewScanner(f)
+	// Commands hold their full output, so lines may be long.
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var c Command
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return Run{}, nil, fmt.Errorf("error reading commands of run %s: %w", id, err)
+		}
+		commands = append(commands, c)
+	}
+	return run, commands, scanner.Err()
+}
+
+// LogPath returns the path of a recorded run's log.
+func LogPath(id string) (string, error) {
+	runsDir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(runsDir, filepath.Base(id), logFile), nil
+}
+
+func loadRun(dir string) (Run, error) {
+	b, err := os.ReadFile(filepath.Join(dir, runFile))
+	if err != nil {
+		return Run{}, err
+	}
+	var run Run
+	if err := json.Unmarshal(b, &run); err != nil {
+		return Run{}, err
+	}
+	return run, nil
+}
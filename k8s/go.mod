@@ -0,0 +1,3 @@
+module github.com/kev-cao/log-console/k8s
+
+go 1.23.2
@@ -0,0 +1,12 @@
+// Package k8s embeds the Kubernetes manifests of the project, so that
+// deploy-cli applies the manifests it was built with instead of a copy of the
+// project on the cluster.
+package k8s
+
+import "embed"
+
+// Manifests holds the manifests and the scripts they run, by their path in
+// this directory, e.g. vault/vault.yaml.
+//
+//go:embed vault
+var Manifests embed.FS
@@ -1,13 +1,27 @@
 package stringutils
 
-import "strings"
+import (
+	"regexp"
+	"strings"
+)
+
+var unquotedValue = regexp.MustCompile(`^[a-zA-Z0-9_./:@%+,=-]*$`)
 
 // BuildEnvBindings converts a map of environment variables to a space-separate list of bindings
-// in the form "key=value".
+// in the form "key=value". Values with characters the shell treats specially are single-quoted.
 func BuildEnvBindings(env map[string]string) string {
 	var envBindings []string
 	for k, v := range env {
-		envBindings = append(envBindings, k+"="+v)
+		envBindings = append(envBindings, k+"="+ShellQuote(v))
 	}
 	return strings.Join(envBindings, " ")
 }
+
+// ShellQuote quotes a string so that the shell reads it as a single word, leaving
+// strings without characters the shell treats specially as they are.
+func ShellQuote(s string) string {
+	if s != "" && unquotedValue.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
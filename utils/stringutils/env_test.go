@@ -0,0 +1,35 @@
+package stringutils
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "plain", input: "https://vault:8200", expected: "https://vault:8200"},
+		{name: "empty", input: "", expected: "''"},
+		{name: "space", input: "a b", expected: "'a b'"},
+		{name: "single quote", input: "it's", expected: `'it'\''s'`},
+		{name: "only single quotes", input: "''", expected: `''\'''\'''`},
+		{name: "dollar", input: "$HOME", expected: "'$HOME'"},
+		{name: "command substitution", input: "$(id)`id`", expected: "'$(id)`id`'"},
+		{name: "newline", input: "line 1\nline 2", expected: "'line 1\nline 2'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quoted := ShellQuote(test.input)
+			require.Equal(t, test.expected, quoted)
+			// The shell reads the quoted string back as the input, unexpanded.
+			output, err := exec.Command("sh", "-c", "printf %s "+quoted).Output()
+			require.NoError(t, err)
+			require.Equal(t, test.input, string(output))
+		})
+	}
+}